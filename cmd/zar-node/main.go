@@ -1,12 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
-	"time"
+	"path/filepath"
+	"runtime"
 	"zar-blockchain/pkg/blockchain"
+	"zar-blockchain/pkg/consensus"
 	"zar-blockchain/pkg/gateway"
+	"zar-blockchain/pkg/mempool"
+	"zar-blockchain/pkg/miner"
 	"zar-blockchain/pkg/rpc"
+	"zar-blockchain/pkg/storage"
 	"zar-blockchain/pkg/utils"
 
 	"zar-blockchain/pkg/wallet"
@@ -18,21 +27,164 @@ import (
 
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		initChain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		verifyChain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "snapshot" {
+		exportSnapshot(os.Args[2:])
+		return
+	}
+
+	minerThreads := flag.Int("miner-threads", runtime.NumCPU(), "number of mining workers (0 disables mining)")
+	validatorKey := flag.String("validator-key", "", "file with the hex private key this node signs proof-of-stake blocks with, created if missing (default <datadir>/<network>/keystore/validator.key)")
+	dataDirRoot := flag.String("datadir", defaultDataDir(), "directory the node keeps its data in")
+	network := flag.String("network", blockchain.Mainnet, "network to join: mainnet, testnet, devnet or one created with init")
+	verify := flag.Bool("verify", false, "replay the whole chain and check it against the stored state before starting")
+	rpcToken := flag.String("rpc-token", os.Getenv("ZAR_RPC_TOKEN"), "token RPC clients must send as \"Authorization: Bearer <token>\" to control the miner (default $ZAR_RPC_TOKEN; without one only local clients can)")
+	snapshotInterval := flag.Int64("snapshot-interval", 0, "take a snapshot of the state every this many blocks (0 takes none)")
+	prune := flag.Int64("prune", 0, "drop the transactions of blocks more than this many below the tip once a snapshot covers them (0 keeps every block)")
+	flag.Parse()
+
+	dd, err := storage.OpenDataDir(*dataDirRoot, *network)
+	if err != nil {
+		fmt.Printf("[CHAIN] Cannot open data directory: %v\n", err)
+		exit(1)
+	}
+	defer dd.Close() // Holds the lock for as long as the node runs
+	if err := logToFile(filepath.Join(dd.Logs(), "node.log")); err != nil {
+		fmt.Printf("[CHAIN] Cannot open log file: %v\n", err)
+	}
+
 	fmt.Println("Starting ZAR Blockchain Node...")
+	fmt.Printf("[CHAIN] Network %s, data in %s\n", *network, dd.Path)
 
 	// Initialize Chain (Load from disk if exists)
-	chain := blockchain.LoadChain(2)
+	chain, err := openChain(dd, *network)
+	if errors.Is(err, blockchain.ErrStateRootMismatch) {
+		fmt.Printf("[CHAIN] %v\n", err)
+		fmt.Printf("[CHAIN] Run zar-node verify --network %s --rebuild to rebuild the state from the blocks\n", *network)
+		exit(1)
+	}
+	if err != nil {
+		fmt.Printf("[CHAIN] Cannot open chain data: %v\n", err)
+		exit(1)
+	}
 	fmt.Printf("Current Blockchain Height: %d\n", len(chain.Blocks))
 	fmt.Printf("Latest Block Hash: %s\n", chain.GetLatestBlock().Hash)
 
+	// YOUR METAMASK ADDRESS
+	myMetaMaskAddr := "0xA048F7cfFb548B05eA90ab94962ED0e9A7fC865b" 
+
+	// Consensus engine selected by the chain parameters. Proof-of-stake
+	// nodes produce blocks with their validator key once it has stake
+	var signer *wallet.Wallet
+	if chain.Params.Engine == blockchain.EnginePoS {
+		keyPath := *validatorKey
+		if keyPath == "" {
+			keyPath = filepath.Join(dd.Keystore(), "validator.key")
+		}
+		w, err := wallet.LoadOrCreate(keyPath)
+		if err != nil {
+			fmt.Printf("[CHAIN] Cannot load validator key: %v\n", err)
+			exit(1)
+		}
+		signer = w
+		fmt.Printf("[CHAIN] Validator address: %s\n", signer.Address)
+	}
+	engine, err := consensus.New(chain.Params, signer)
+	if err != nil {
+		fmt.Printf("[CHAIN] %v\n", err)
+		exit(1)
+	}
+	chain.SetEngine(engine)
+	fmt.Printf("[CHAIN] Consensus engine: %s\n", chain.Params.Engine)
+	if *verify {
+		report, err := chain.Verify()
+		if err != nil {
+			fmt.Printf("[VERIFY] %v\n", err)
+			exit(1)
+		}
+		printReport(report)
+		if !report.OK() {
+			fmt.Println("[VERIFY] Not starting, run zar-node verify --rebuild to rebuild the state from the blocks")
+			exit(1)
+		}
+	}
+	if *prune > 0 && *snapshotInterval <= 0 {
+		fmt.Println("[CHAIN] --prune only prunes up to a snapshot, set --snapshot-interval to take them")
+	}
+	chain.SetSnapshots(blockchain.SnapshotConfig{Interval: *snapshotInterval, KeepBodies: *prune})
+
+	// Pending transactions shared by the RPC server, bridge and miner
+	chain.SetTxPool(mempool.New(mempool.DefaultConfig()))
+
+	// Validators report double signs they see and get a share of the slash,
+	// and vote on every checkpoint that reaches their main chain
+	if signer != nil {
+		evidence := chain.SubscribeEvidence()
+		go func() {
+			for ev := range evidence {
+				if err := chain.ReportEvidence(ev, signer); err != nil {
+					fmt.Printf("[STAKING] Cannot report double sign at height %d: %v\n", ev.Height(), err)
+				}
+			}
+		}()
+		heads := chain.SubscribeHeads()
+		go func() {
+			for head := range heads {
+				if head.Index%chain.Params.CheckpointInterval != 0 {
+					continue
+				}
+				if err := chain.VoteCheckpoint(head, signer); err != nil {
+					fmt.Printf("[FINALITY] Not voting for checkpoint %d: %v\n", head.Index, err)
+				}
+			}
+		}()
+	}
+
 	// Automated Port Forwarding (UPnP)
 	utils.SetupUPnP(8545)
 
+	// The faucet and the bridge pay out of their own accounts, which the
+	// operator funds
+	faucet, err := wallet.LoadOrCreate(filepath.Join(dd.Keystore(), "faucet.key"))
+	if err != nil {
+		fmt.Printf("[FAUCET] Cannot load faucet key: %v\n", err)
+		exit(1)
+	}
+	fmt.Printf("[FAUCET] Faucet address: %s (balance %s ZAR)\n", faucet.Address, chain.GetBalance(faucet.Address))
+	bridgeWallet, err := wallet.LoadOrCreate(filepath.Join(dd.Keystore(), "bridge.key"))
+	if err != nil {
+		fmt.Printf("[BRIDGE] Cannot load bridge key: %v\n", err)
+		exit(1)
+	}
+	fmt.Printf("[BRIDGE] Bridge address: %s (balance %s ZAR)\n", bridgeWallet.Address, chain.GetBalance(bridgeWallet.Address))
+
 	// Initialize Universal Gateway (Bridge)
 	gw := gateway.NewGateway(chain, 0.01) // 1% Bridge Fee
+	gw.Wallet = bridgeWallet
+	if err := gw.Load(filepath.Join(dd.Bridge(), "orders.json")); err != nil {
+		fmt.Printf("[BRIDGE] Cannot load bridge orders: %v\n", err)
+		exit(1)
+	}
+	gw.WatchFinality()
+
+	// Miner, controllable over RPC with miner_start/miner_stop
+	minerCfg := miner.DefaultConfig()
+	minerCfg.Workers = *minerThreads
+	minerCfg.Coinbase = myMetaMaskAddr
+	m := miner.New(chain, minerCfg)
 
 	// Start RPC Server for MetaMask + Bridge
 	rpcServer := rpc.NewRPCServer(chain, gw, 8545)
+	rpcServer.Miner = m
+	rpcServer.Faucet = faucet
+	rpcServer.AdminToken = *rpcToken
 
 	domain := os.Getenv("DUCKDNS_DOMAIN")
 	token := os.Getenv("DUCKDNS_TOKEN")
@@ -58,25 +210,11 @@ func main() {
 
 	fmt.Printf("Node Wallet Address: %s\n", w.Address)
 
-	// YOUR METAMASK ADDRESS
-	myMetaMaskAddr := "0xA048F7cfFb548B05eA90ab94962ED0e9A7fC865b" 
-
-	// Public Treasury & Staker Addresses
-	treasuryAddr := "0xTreasuryFundAddress1234567890abcdef"
-	stakerAddr := "0xStakerAddress1234567890abcdef"
-
 	// START REAL CONTINUOUS MINING
-	fmt.Println("\n[MINER] Starting Background Mining Loop...")
-	go func() {
-		for {
-			fmt.Println("[MINER] Mining next block...")
-			chain.MinePendingTransactions(myMetaMaskAddr, stakerAddr, treasuryAddr)
-			fmt.Printf("[MINER] Block Mined! Height: %d | Hash: %s\n", len(chain.Blocks), chain.GetLatestBlock().Hash)
-			
-			// Wait 10 seconds between blocks (adjust for difficulty)
-			time.Sleep(10 * time.Second)
-		}
-	}()
+	if *minerThreads > 0 {
+		fmt.Println("\n[MINER] Starting Background Mining Loop...")
+		m.Start()
+	}
 
 
 	// Start the Auto-Detector (Scanner)
@@ -92,7 +230,299 @@ func main() {
 	select {}
 }
 
+// initChain creates the chain data of a network. Built-in networks start
+// from their own genesis; any other network name needs a genesis file.
+// Either can start from a snapshot of the chain that genesis starts
+// instead, which on proof-of-stake networks must be pinned with --trust:
+//
+//	zar-node init --network mynet --genesis genesis.json
+//	zar-node init --network testnet --snapshot testnet-snapshot.json
+//	zar-node init --network mynet --genesis genesis.json --snapshot mynet-snapshot.json --trust <hash>
+func initChain(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	genesisPath := fs.String("genesis", "", "genesis file of a network other than mainnet, testnet and devnet")
+	snapshotPath := fs.String("snapshot", "", "snapshot file written by zar-node snapshot to start from instead of the genesis block")
+	trusted := fs.String("trust", "", "hash of a block at or above the snapshot block you trust, such as a finalized block from a node you run; required for proof-of-stake networks")
+	dataDirRoot := fs.String("datadir", defaultDataDir(), "directory the node keeps its data in")
+	network := fs.String("network", blockchain.Mainnet, "name of the network")
+	fs.Parse(args)
+
+	genesis := blockchain.NetworkGenesis(*network)
+	switch {
+	case genesis != nil && *genesisPath != "":
+		fmt.Printf("[CHAIN] %s has a built-in genesis, pick another network name for %s\n", *network, *genesisPath)
+		os.Exit(1)
+	case genesis == nil && *genesisPath == "":
+		fmt.Printf("[CHAIN] Network %s needs a --genesis file\n", *network)
+		os.Exit(1)
+	case genesis == nil && *genesisPath != "":
+		g, err := blockchain.LoadGenesis(*genesisPath)
+		if err != nil {
+			fmt.Printf("[CHAIN] %v\n", err)
+			os.Exit(1)
+		}
+		genesis = g
+	}
+
+	dd, err := storage.OpenDataDir(*dataDirRoot, *network)
+	if err != nil {
+		fmt.Printf("[CHAIN] Cannot open data directory: %v\n", err)
+		os.Exit(1)
+	}
+	defer dd.Close()
+	blocks, state, err := openStores(dd)
+	if err != nil {
+		fmt.Printf("[CHAIN] Cannot open chain data: %v\n", err)
+		os.Exit(1)
+	}
+	defer blocks.Close()
+	defer state.Close()
+	if exists, err := blockchain.HasChain(state); err != nil || exists {
+		fmt.Printf("[CHAIN] %s already holds a chain, remove it to start a new one\n", dd.Path)
+		os.Exit(1)
+	}
+	if *snapshotPath != "" {
+		importSnapshot(*snapshotPath, genesis, *trusted, blocks, state)
+		fmt.Printf("[CHAIN] Initialized %s in %s\n", *network, dd.Path)
+		return
+	}
+	chain, err := blockchain.OpenChain(blocks, state, genesis)
+	if err != nil {
+		fmt.Printf("[CHAIN] Cannot initialize chain: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("[CHAIN] Initialized %s (chain %d) with genesis %s in %s\n", *network, chain.Params.ChainID, chain.Blocks[0].Hash, dd.Path)
+}
+
+// verifyChain replays a network's chain from genesis and reports the
+// first block that fails and any difference with the stored state:
+//
+//	zar-node verify --network testnet [--rebuild]
+func verifyChain(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	dataDirRoot := fs.String("datadir", defaultDataDir(), "directory the node keeps its data in")
+	network := fs.String("network", blockchain.Mainnet, "name of the network")
+	rebuild := fs.Bool("rebuild", false, "replace the stored state with the replayed one if every block verifies")
+	fs.Parse(args)
+
+	dd, err := storage.OpenDataDir(*dataDirRoot, *network)
+	if err != nil {
+		fmt.Printf("[VERIFY] Cannot open data directory: %v\n", err)
+		os.Exit(1)
+	}
+	defer dd.Close()
+	// A state that doesn't match the head is what verify is for
+	chain, err := openChain(dd, *network)
+	if errors.Is(err, blockchain.ErrStateRootMismatch) {
+		fmt.Printf("[VERIFY] %v\n", err)
+	} else if err != nil {
+		fmt.Printf("[VERIFY] Cannot open chain data: %v\n", err)
+		os.Exit(1)
+	}
+	engine, err := consensus.New(chain.Params, nil)
+	if err != nil {
+		fmt.Printf("[VERIFY] %v\n", err)
+		os.Exit(1)
+	}
+	chain.SetEngine(engine)
+
+	fmt.Printf("[VERIFY] Replaying %d blocks of %s...\n", len(chain.Blocks), *network)
+	report, err := chain.Verify()
+	if err != nil {
+		fmt.Printf("[VERIFY] %v\n", err)
+		os.Exit(1)
+	}
+	printReport(report)
+	switch {
+	case report.OK():
+	case *rebuild && report.Divergent == nil:
+		if err := chain.RebuildState(report); err != nil {
+			fmt.Printf("[VERIFY] Cannot rebuild the state: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("[VERIFY] Rebuilt the stored state from the blocks")
+	default:
+		os.Exit(1)
+	}
+}
+
+// importSnapshot starts the chain in blocks and state from the snapshot
+// file at path, pinned by the trusted block hash if set, and adds the
+// blocks that came with it. The snapshot must be of the chain genesis
+// starts, whose rules the node runs by.
+func importSnapshot(path string, genesis *blockchain.Genesis, trusted string, blocks, state *storage.Disk) {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("[CHAIN] Cannot open snapshot: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	engine, err := consensus.New(genesis.Config, nil)
+	if err != nil {
+		fmt.Printf("[CHAIN] %v\n", err)
+		os.Exit(1)
+	}
+	chain, next, err := blockchain.ImportSnapshot(f, genesis, engine, trusted, blocks, state)
+	if err != nil {
+		fmt.Printf("[CHAIN] Cannot import snapshot: %v\n", err)
+		os.Exit(1)
+	}
+	for _, b := range next {
+		if err := chain.AddBlock(b); err != nil {
+			fmt.Printf("[CHAIN] Stopped at block %d of the snapshot: %v\n", b.Index, err)
+			break
+		}
+	}
+	fmt.Printf("[CHAIN] Chain %d at block %d (%s)\n", chain.Params.ChainID, chain.GetLatestBlock().Index, chain.GetLatestBlock().Hash)
+}
+
+// exportSnapshot writes the newest snapshot of a network's chain to a
+// file another node can be initialized from:
+//
+//	zar-node snapshot --network testnet --out testnet-snapshot.json
+func exportSnapshot(args []string) {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	dataDirRoot := fs.String("datadir", defaultDataDir(), "directory the node keeps its data in")
+	network := fs.String("network", blockchain.Mainnet, "name of the network")
+	out := fs.String("out", "", "file to write the snapshot to (default <network>-snapshot.json)")
+	fs.Parse(args)
+	if *out == "" {
+		*out = *network + "-snapshot.json"
+	}
+
+	dd, err := storage.OpenDataDir(*dataDirRoot, *network)
+	if err != nil {
+		fmt.Printf("[CHAIN] Cannot open data directory: %v\n", err)
+		os.Exit(1)
+	}
+	defer dd.Close()
+	chain, err := openChain(dd, *network)
+	if err != nil {
+		fmt.Printf("[CHAIN] Cannot open chain data: %v\n", err)
+		os.Exit(1)
+	}
+	var buf bytes.Buffer
+	snap, err := chain.ExportSnapshot(&buf)
+	if errors.Is(err, blockchain.ErrNoSnapshot) {
+		fmt.Println("[CHAIN] The chain has no snapshot yet, run the node with --snapshot-interval to take them")
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("[CHAIN] Cannot export snapshot: %v\n", err)
+		os.Exit(1)
+	}
+	if err := storage.WriteFile(*out, buf.Bytes(), 0644); err != nil {
+		fmt.Printf("[CHAIN] Cannot write snapshot: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("[CHAIN] Wrote the snapshot at block %d (%s) and the %d blocks after it to %s\n", snap.Height, snap.Hash, chain.GetLatestBlock().Index-snap.Height, *out)
+}
+
+func printReport(r *blockchain.VerifyReport) {
+	if r.Divergent != nil {
+		fmt.Printf("[VERIFY] Block %d (%s) is invalid: %v\n", r.Divergent.Index, r.Divergent.Hash, r.Err)
+		fmt.Printf("[VERIFY] %d blocks before it verified\n", r.Blocks)
+		return
+	}
+	fmt.Printf("[VERIFY] All %d blocks verified\n", r.Blocks)
+	for _, m := range r.Mismatches {
+		fmt.Printf("[VERIFY] State mismatch: %s\n", m)
+	}
+	if len(r.Mismatches) == 0 {
+		fmt.Println("[VERIFY] Stored state matches the replayed state")
+	}
+}
+
+// defaultDataDir is ~/.zar, or zardata in the working directory if there
+// is no home directory.
+func defaultDataDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "zardata"
+	}
+	return filepath.Join(home, ".zar")
+}
 
+// exit ends the node with code, after the output still on its way to
+// the log file once logToFile is in place.
+var exit = os.Exit
 
+// logToFile copies everything the node prints to the file at path.
+func logToFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	out := io.MultiWriter(os.Stdout, f)
+	done := make(chan struct{})
+	go func() {
+		io.Copy(out, r)
+		close(done)
+	}()
+	os.Stdout = w
+	exit = func(code int) {
+		w.Close()
+		<-done
+		f.Close()
+		os.Exit(code)
+	}
+	return nil
+}
 
+func openStores(dd *storage.DataDir) (blocks, state *storage.Disk, err error) {
+	if blocks, err = storage.Open(dd.Blocks()); err != nil {
+		return nil, nil, err
+	}
+	if state, err = storage.Open(dd.State()); err != nil {
+		blocks.Close()
+		return nil, nil, err
+	}
+	return blocks, state, nil
+}
 
+// openChain opens the chain of network in dd. An empty store starts at
+// the network's genesis; on the main network, a chaindata.json in the
+// working directory from before the block store is imported instead.
+func openChain(dd *storage.DataDir, network string) (*blockchain.Chain, error) {
+	blocks, state, err := openStores(dd)
+	if err != nil {
+		return nil, err
+	}
+	exists, err := blockchain.HasChain(state)
+	if err != nil {
+		return nil, err
+	}
+	genesis := blockchain.NetworkGenesis(network)
+	if !exists && genesis == nil {
+		return nil, fmt.Errorf("network %s is not initialized, run zar-node init --network %s --genesis <file>", network, network)
+	}
+	if _, statErr := os.Stat("chaindata.json"); !exists && network == blockchain.Mainnet && statErr == nil {
+		fmt.Println("[CHAIN] Importing chaindata.json into the block store...")
+		chain, err := blockchain.LoadChain("chaindata.json")
+		if err != nil {
+			return nil, fmt.Errorf("cannot import chaindata.json, move it away to start a new chain: %w", err)
+		}
+		if err := chain.Persist(blocks, state); err != nil {
+			return nil, err
+		}
+		return chain, nil
+	}
+	if !exists {
+		return blockchain.OpenChain(blocks, state, genesis)
+	}
+	// Chains imported from chaindata.json predate the built-in genesis, so
+	// a stored chain is matched to its network by chain ID
+	chain, err := blockchain.OpenChain(blocks, state, nil)
+	if chain == nil {
+		return nil, err
+	}
+	if genesis != nil && chain.Params.ChainID != genesis.Config.ChainID {
+		return nil, fmt.Errorf("%s holds chain %d, not %s (chain %d)", dd.Path, chain.Params.ChainID, network, genesis.Config.ChainID)
+	}
+	return chain, err
+}
@@ -0,0 +1,190 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Decimals is the number of fractional digits of one ZAR (1 ZAR = 10^18 wei).
+const Decimals = 18
+
+var weiPerZAR = new(big.Int).Exp(big.NewInt(10), big.NewInt(Decimals), nil)
+
+// Amount is an exact ZAR value stored as an integer number of wei.
+// The zero value is a valid amount of 0. Amounts are immutable: every
+// arithmetic method returns a new value and never modifies its receiver.
+type Amount struct {
+	wei *big.Int
+}
+
+// NewAmount returns an Amount of exactly wei units.
+func NewAmount(wei *big.Int) Amount {
+	if wei == nil {
+		return Amount{}
+	}
+	return Amount{wei: new(big.Int).Set(wei)}
+}
+
+// ZAR returns an Amount of n whole ZAR.
+func ZAR(n int64) Amount {
+	return Amount{wei: new(big.Int).Mul(big.NewInt(n), weiPerZAR)}
+}
+
+// ParseZAR parses a decimal ZAR string such as "12.5" or "1e-3" exactly.
+// Values with more than 18 fractional digits are rejected.
+func ParseZAR(s string) (Amount, error) {
+	r, err := parseWeiRat(s)
+	if err != nil {
+		return Amount{}, err
+	}
+	if !r.IsInt() {
+		return Amount{}, fmt.Errorf("ZAR amount %q has more than %d decimals", s, Decimals)
+	}
+	return Amount{wei: new(big.Int).Set(r.Num())}, nil
+}
+
+// ZARFromFloat converts an approximate float value (e.g. an oracle price
+// product) to an Amount using its shortest decimal representation,
+// rounded to the nearest wei.
+func ZARFromFloat(f float64) Amount {
+	a, _ := roundZAR(strconv.FormatFloat(f, 'g', -1, 64))
+	return a
+}
+
+// roundZAR parses a decimal ZAR string and rounds it to the nearest wei.
+func roundZAR(s string) (Amount, error) {
+	r, err := parseWeiRat(s)
+	if err != nil {
+		return Amount{}, err
+	}
+	num, den := r.Num(), r.Denom()
+	q, m := new(big.Int).QuoRem(num, den, new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(m), big.NewInt(2)).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(int64(num.Sign())))
+	}
+	return Amount{wei: q}, nil
+}
+
+func parseWeiRat(s string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return nil, fmt.Errorf("invalid ZAR amount %q", s)
+	}
+	return r.Mul(r, new(big.Rat).SetInt(weiPerZAR)), nil
+}
+
+func (a Amount) int() *big.Int {
+	if a.wei == nil {
+		return new(big.Int)
+	}
+	return a.wei
+}
+
+// Wei returns a copy of the amount in wei.
+func (a Amount) Wei() *big.Int {
+	return new(big.Int).Set(a.int())
+}
+
+func (a Amount) Add(b Amount) Amount {
+	return Amount{wei: new(big.Int).Add(a.int(), b.int())}
+}
+
+func (a Amount) Sub(b Amount) Amount {
+	return Amount{wei: new(big.Int).Sub(a.int(), b.int())}
+}
+
+// MulFrac returns a * num / den, truncated towards zero.
+func (a Amount) MulFrac(num, den int64) Amount {
+	v := new(big.Int).Mul(a.int(), big.NewInt(num))
+	return Amount{wei: v.Quo(v, big.NewInt(den))}
+}
+
+// MulDiv returns a * num / den, truncated towards zero.
+func (a Amount) MulDiv(num, den Amount) Amount {
+	v := new(big.Int).Mul(a.int(), num.int())
+	return Amount{wei: v.Quo(v, den.int())}
+}
+
+// Bps returns the given number of basis points (1/10000) of a.
+func (a Amount) Bps(bps int64) Amount {
+	return a.MulFrac(bps, 10000)
+}
+
+func (a Amount) Cmp(b Amount) int {
+	return a.int().Cmp(b.int())
+}
+
+func (a Amount) Sign() int {
+	return a.int().Sign()
+}
+
+func (a Amount) IsZero() bool {
+	return a.Sign() == 0
+}
+
+// String formats the amount in ZAR without trailing zeros, e.g. "5.9994".
+func (a Amount) String() string {
+	v := a.int()
+	neg := v.Sign() < 0
+	abs := new(big.Int).Abs(v)
+	whole, frac := new(big.Int).QuoRem(abs, weiPerZAR, new(big.Int))
+
+	s := whole.String()
+	if frac.Sign() != 0 {
+		fs := fmt.Sprintf("%0*s", Decimals, frac.String())
+		s += "." + strings.TrimRight(fs, "0")
+	}
+	if neg {
+		s = "-" + s
+	}
+	return s
+}
+
+// Float64 returns the nearest float64 ZAR value. It is only meant for
+// display and for re-hashing legacy blocks, never for accounting.
+func (a Amount) Float64() float64 {
+	f, _ := strconv.ParseFloat(a.String(), 64)
+	return f
+}
+
+// MarshalJSON encodes the amount as a quoted decimal wei string so that
+// no precision is lost by JSON number parsers.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.int().String())
+}
+
+// UnmarshalJSON accepts a quoted wei string (current format) or a bare
+// JSON number holding a ZAR value (legacy float-based chaindata.json).
+// Legacy numbers are converted from their decimal text, not via float64,
+// so they load exactly as they were written.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*a = Amount{}
+		return nil
+	}
+
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		v, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			return fmt.Errorf("invalid wei amount %q", s)
+		}
+		a.wei = v
+		return nil
+	}
+
+	legacy, err := roundZAR(string(data))
+	if err != nil {
+		return err
+	}
+	*a = legacy
+	return nil
+}
@@ -3,23 +3,79 @@ package blockchain
 import (
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/big"
 	"time"
+
+	"zar-blockchain/pkg/wallet"
 )
 
+// Block versions. Blocks written before wei accounting (version 0) are
+// hashed with their amounts encoded as float64 ZAR, exactly as they were
+// when they were mined, so existing chain data still validates. Blocks
+// before version 2 require a number of leading zero hex digits
+// (Difficulty); later ones carry a compact 256-bit target (Bits). From
+// version 3 the hash covers only the header, which commits to the
+// transactions and the resulting state through Merkle roots.
+const (
+	BlockVersionLegacy  = 0
+	BlockVersionWei     = 1
+	BlockVersionCompact = 2
+	BlockVersionHeader  = 3
+
+	CurrentBlockVersion = BlockVersionHeader
+)
+
+var (
+	ErrMissingBlockSignature = errors.New("block is not signed")
+	ErrInvalidBlockSignature = errors.New("block signature does not match its validator")
+)
+
+// Header is the part of a block covered by its hash.
+type Header struct {
+	Version    int    `json:"version,omitempty"`
+	Index      int64  `json:"index"`
+	Timestamp  int64  `json:"timestamp"`
+	PrevHash   string `json:"prev_hash"`
+	TxRoot     string `json:"tx_root,omitempty"`    // Merkle root of the transactions and their number
+	StateRoot  string `json:"state_root,omitempty"` // Sparse Merkle root of the accounts after the block
+	Nonce      int64  `json:"nonce"`
+	Difficulty int    `json:"difficulty,omitempty"`  // Leading zero hex digits, versions before 2 only
+	Bits       uint32 `json:"bits,omitempty"`        // Compact proof-of-work target
+	Coinbase   string `json:"coinbase,omitempty"`    // Receives the transaction fees of the block
+	Validator  string `json:"validator,omitempty"`   // PoS Validator Address
+	Randomness string `json:"randomness,omitempty"`  // PoS seed for electing the next leader
+	VRFProof   string `json:"vrf_proof,omitempty"`   // Validator's VRF proof over the parent's randomness
+	ConfigHash string `json:"config_hash,omitempty"` // Params.Hash of the chain, genesis block only
+}
+
 type Block struct {
-	Index        int64         `json:"index"`
-	Timestamp    int64         `json:"timestamp"`
-	PrevHash     string        `json:"prev_hash"`
+	Header
 	Hash         string        `json:"hash"`
 	Transactions []Transaction `json:"transactions"`
-	Nonce        int64         `json:"nonce"`
-	Difficulty   int           `json:"difficulty"`
-	Validator    string        `json:"validator,omitempty"` // PoS Validator Address
-	Signature    string        `json:"signature,omitempty"` // Validator Signature
+	ChainWork    *big.Int      `json:"chain_work,omitempty"` // Cumulative work up to and including this block
+	Signature    string        `json:"signature,omitempty"`  // Validator Signature
 }
 
 type Transaction struct {
+	ID        string `json:"id"`
+	Type      string `json:"type,omitempty"` // TxTransfer, TxStake or TxUnstake
+	Sender    string `json:"sender"`
+	Receiver  string `json:"receiver"`
+	Amount    Amount `json:"amount"`
+	Fee       Amount `json:"fee,omitzero"`    // Tip paid to the block's coinbase on top of Amount
+	Nonce     uint64 `json:"nonce,omitempty"` // Sender's account nonce (unused for system txs)
+	Timestamp int64  `json:"timestamp"`
+	Signature string `json:"signature"`
+	RawTx     string `json:"raw_tx,omitempty"` // Signed Ethereum envelope for wallet-originated txs
+
+	Evidence *DoubleSignEvidence `json:"evidence,omitempty"` // TxEvidence only
+}
+
+// legacyTransaction is the float-based transaction encoding used by
+// version 0 blocks.
+type legacyTransaction struct {
 	ID        string  `json:"id"`
 	Sender    string  `json:"sender"`
 	Receiver  string  `json:"receiver"`
@@ -28,34 +84,120 @@ type Transaction struct {
 	Signature string  `json:"signature"`
 }
 
+func (b *Block) hashTransactions() interface{} {
+	if b.Version != BlockVersionLegacy {
+		return b.Transactions
+	}
+	txs := make([]legacyTransaction, len(b.Transactions))
+	for i, tx := range b.Transactions {
+		txs[i] = legacyTransaction{
+			ID:        tx.ID,
+			Sender:    tx.Sender,
+			Receiver:  tx.Receiver,
+			Amount:    tx.Amount.Float64(),
+			Timestamp: tx.Timestamp,
+			Signature: tx.Signature,
+		}
+	}
+	return txs
+}
+
+// hashBits returns the target committed to by the block hash. Older
+// blocks were hashed without one.
+func (b *Block) hashBits() uint32 {
+	if b.Version < BlockVersionCompact {
+		return 0
+	}
+	return b.Bits
+}
+
+// CalculateHash returns the block hash committed to by h.
+func (h *Header) CalculateHash() string {
+	data, _ := json.Marshal(h)
+	hash := sha256.Sum256(data)
+	return fmt.Sprintf("%x", hash)
+}
+
 func (b *Block) CalculateHash() string {
+	if b.Version >= BlockVersionHeader {
+		return b.Header.CalculateHash()
+	}
+
+	// Earlier versions hash the whole transaction list
 	data, _ := json.Marshal(struct {
-		Index        int64         `json:"index"`
-		Timestamp    int64         `json:"timestamp"`
-		PrevHash     string        `json:"prev_hash"`
-		Transactions []Transaction `json:"transactions"`
-		Nonce        int64         `json:"nonce"`
-		Validator    string        `json:"validator"`
+		Version      int         `json:"version,omitempty"`
+		Index        int64       `json:"index"`
+		Timestamp    int64       `json:"timestamp"`
+		PrevHash     string      `json:"prev_hash"`
+		Transactions interface{} `json:"transactions"`
+		Nonce        int64       `json:"nonce"`
+		Validator    string      `json:"validator"`
+		Coinbase     string      `json:"coinbase,omitempty"`
+		Bits         uint32      `json:"bits,omitempty"`
 	}{
+		Version:      b.Version,
 		Index:        b.Index,
 		Timestamp:    b.Timestamp,
 		PrevHash:     b.PrevHash,
-		Transactions: b.Transactions,
+		Transactions: b.hashTransactions(),
 		Nonce:        b.Nonce,
 		Validator:    b.Validator,
+		Coinbase:     b.Coinbase,
+		Bits:         b.hashBits(),
 	})
 	hash := sha256.Sum256(data) // Keeping SHA256 for PoW mining (it's traditional)
 	return fmt.Sprintf("%x", hash)
 }
 
+// SigningPayload returns the bytes the validator signs: the block hash,
+// bound to the chain ID like votes and transactions.
+func (b *Block) SigningPayload(chainID int64) []byte {
+	data, _ := json.Marshal(struct {
+		ChainID int64  `json:"chain_id"`
+		Type    string `json:"type"`
+		Hash    string `json:"hash"`
+	}{chainID, "block", b.Hash})
+	return data
+}
+
+// Sign signs the block for the chain chainID with w, which must be the
+// block's validator. The signature is not part of the header, so it
+// doesn't change the hash.
+func (b *Block) Sign(w *wallet.Wallet, chainID int64) error {
+	if !wallet.SameAddress(b.Validator, w.Address) {
+		return fmt.Errorf("wallet %s cannot sign for validator %s", w.Address, b.Validator)
+	}
+	sig, err := w.Sign(b.SigningPayload(chainID))
+	if err != nil {
+		return err
+	}
+	b.Signature = sig
+	return nil
+}
+
+// VerifySignature checks that the block was signed for the chain chainID
+// by the key owning b.Validator.
+func (b *Block) VerifySignature(chainID int64) error {
+	if b.Signature == "" {
+		return ErrMissingBlockSignature
+	}
+	if !wallet.VerifySignature(b.Validator, b.SigningPayload(chainID), b.Signature) {
+		return ErrInvalidBlockSignature
+	}
+	return nil
+}
 
-func NewBlock(index int64, prevHash string, txs []Transaction, difficulty int) *Block {
+func NewBlock(index int64, prevHash string, txs []Transaction, bits uint32) *Block {
 	b := &Block{
-		Index:        index,
-		Timestamp:    time.Now().Unix(),
-		PrevHash:     prevHash,
+		Header: Header{
+			Version:   CurrentBlockVersion,
+			Index:     index,
+			Timestamp: time.Now().Unix(),
+			PrevHash:  prevHash,
+			TxRoot:    TxRoot(txs),
+			Bits:      bits,
+		},
 		Transactions: txs,
-		Difficulty:   difficulty,
 	}
 	b.Hash = b.CalculateHash()
 	return b
@@ -1,158 +1,514 @@
 package blockchain
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/big"
 	"os"
-	"strings"
 	"sync"
+	"time"
+
+	"zar-blockchain/pkg/wallet"
 )
 
-const DeveloperAddress = "0xA048F7cfFb548B05eA90ab94962ED0e9A7fC865b"
-const FeePercentage = 0.0001 // 0.01%
+// Block size limits. Reward transactions count towards them too.
+const (
+	MaxBlockTxs   = 1000
+	MaxBlockBytes = 1 << 20 // Encoded transactions, 1 MiB
+)
 
-type Chain struct {
-	Blocks     []*Block           `json:"blocks"`
-	Difficulty int                `json:"difficulty"`
-	Mempool    []Transaction      `json:"mempool"`
-	Balances   map[string]float64 `json:"balances"`
-	mu         sync.Mutex
+// TxPool is the store of transactions waiting to be mined. The chain
+// reads pending transactions from it and removes them once included.
+// mempool.Pool is the node's implementation.
+type TxPool interface {
+	Add(tx Transaction) error
+	Remove(hashes ...string)
+	RemoveIncluded(txs []Transaction)
+	Pending() []Transaction
+	// SelectForBlock returns the best-paying transactions that fit in
+	// maxTxs/maxBytes, keeping each sender's nonce order. apply is called
+	// on every candidate; ones it rejects are dropped from the pool.
+	SelectForBlock(maxTxs, maxBytes int, apply func(Transaction) error) []Transaction
 }
 
+type Chain struct {
+	Blocks   []*Block          `json:"blocks"`
+	Params   Params            `json:"params"`
+	Balances map[string]Amount `json:"balances"`
+	Nonces   map[string]uint64 `json:"nonces"` // Next expected nonce per account (lowercase address)
+	// Bonds and Unbonding hold the staked funds, see State
+	Bonds     map[string]Amount `json:"bonds,omitempty"`
+	Unbonding map[string]Amount `json:"unbonding,omitempty"`
+	Slashes   map[string]int64  `json:"slashes,omitempty"`
+	// Undo holds, per block hash, the accounts the block changed as they
+	// were before it, so past states can be reconstructed
+	Undo map[string]StateUndo `json:"undo,omitempty"`
+	// Votes holds checkpoint votes by voteKey(height, validator) above the
+	// finalized checkpoint; Finalized and Safe are the hashes of the latest
+	// finalized and justified checkpoints, see finality.go
+	Votes     map[string]Vote `json:"votes,omitempty"`
+	Finalized string          `json:"finalized,omitempty"`
+	Safe      string          `json:"safe,omitempty"`
+	// SideBlocks holds known blocks off the main chain by hash
+	SideBlocks map[string]*Block `json:"side_blocks,omitempty"`
+	// SavedPending holds the mempool as of the last save; SetTxPool
+	// re-queues it so pending transactions survive a restart
+	SavedPending []Transaction `json:"mempool"`
+	Pool         TxPool        `json:"-"`
+	Engine       Engine        `json:"-"`
+	mu           sync.Mutex
+	genesis      *Genesis // What the chain started from, nil if imported from chaindata.json
+	blockDB      Database // Where the chain is persisted, see store.go
+	stateDB      Database
+	unsaved      []StateUndo // Changes of state saves that failed
+	snapshots    SnapshotConfig
+	snaps        []snapshotRef     // Stored snapshots, lowest first
+	bodiesFrom   int64             // Lowest main chain block whose body and undo data are kept
+	byHash       map[string]*Block // Every main and side block
+	signers      map[string]*Block // First block by voteKey(height, validator), see checkDoubleSign
+	headSubs     []chan *Block
+	reorgSubs    []chan *ReorgEvent
+	txSubs       []chan Transaction
+	evidenceSubs []chan *DoubleSignEvidence
+	finalSubs    []chan *Block
+	voterSets    map[string]map[string]Amount // Voting power by checkpoint hash
+}
 
+// NewChain starts a main network chain whose initial proof-of-work target
+// is equivalent to the given number of leading zero hex digits.
 func NewChain(difficulty int) *Chain {
-	genesisBlock := NewBlock(0, "0", []Transaction{}, difficulty)
-	// genesisBlock.Mine() // Avoid mining on init to keep it fast if needed
-	return &Chain{
-		Blocks:     []*Block{genesisBlock},
-		Difficulty: difficulty,
-		Balances:   make(map[string]float64),
-	}
+	g := DefaultGenesis()
+	g.Bits = DifficultyToBits(difficulty)
+	return g.chain()
 }
 
+// NewChainWithParams starts a chain with the given consensus rules on the
+// main network's genesis time and no allocations. A proof-of-work chain
+// starts at the easiest target.
+func NewChainWithParams(p Params) *Chain {
+	g := DefaultGenesis()
+	g.Config = p.withDefaults()
+	return g.chain()
+}
 
 func (c *Chain) GetLatestBlock() *Block {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tip()
+}
+
+// Height returns the index of the latest block.
+func (c *Chain) Height() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tip().Index
+}
+
+func (c *Chain) tip() *Block {
 	return c.Blocks[len(c.Blocks)-1]
 }
 
-// GetBalance returns the balance for an address, normalizing to lowercase
-func (c *Chain) GetBalance(addr string) float64 {
+// TotalWork returns the cumulative proof-of-work of the chain.
+func (c *Chain) TotalWork() *big.Int {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	// Check both original and lowercase
-	if bal, ok := c.Balances[addr]; ok {
-		return bal
-	}
-	return c.Balances[strings.ToLower(addr)]
+	return new(big.Int).Set(c.tip().ChainWork)
 }
 
+// GetBalance returns the balance for an address, normalizing to lowercase
+func (c *Chain) GetBalance(addr string) Amount {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Balances[accountKey(addr)]
+}
 
-func (c *Chain) AddBlock(block *Block) error {
+// GetNonce returns the number of confirmed transactions sent by addr,
+// which is also the nonce its next transaction must use.
+func (c *Chain) GetNonce(addr string) uint64 {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	return c.Nonces[accountKey(addr)]
+}
 
-	latest := c.GetLatestBlock()
-	if block.PrevHash != latest.Hash {
-		return errors.New("invalid previous hash")
+// SetTxPool attaches the mempool and re-queues transactions that were
+// pending when the chain was last saved.
+func (c *Chain) SetTxPool(pool TxPool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Pool = pool
+	st := c.state().Copy()
+	for _, tx := range c.SavedPending {
+		if err := st.ApplyTransaction(tx, ""); err != nil {
+			continue
+		}
+		pool.Add(tx)
 	}
+	c.SavedPending = nil
+}
 
-	if !block.ValidateHash() {
-		return errors.New("invalid block hash or difficulty")
+func (c *Chain) pendingTxs() []Transaction {
+	if c.Pool == nil {
+		return nil
 	}
+	return c.Pool.Pending()
+}
 
-	// Update Balances
-	for _, tx := range block.Transactions {
-		isSystemRoute := (tx.Sender == "SYSTEM" || tx.Sender == "FAUCET" || tx.Sender == DeveloperAddress)
-		
-		if tx.Sender != "SYSTEM" {
-			c.Balances[tx.Sender] -= tx.Amount
-		}
+// AddPendingTransaction validates tx against the pending state and queues
+// it in the mempool. Checking and queueing happen under the chain lock so
+// concurrent submissions can't both spend the same balance.
+func (c *Chain) AddPendingTransaction(tx Transaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.addPending(tx)
+}
 
-		if isSystemRoute {
-			c.Balances[tx.Receiver] += tx.Amount
-		} else {
-			// Apply 0.01% developer fee to regular user transactions
-			fee := tx.Amount * FeePercentage
-			netAmount := tx.Amount - fee
-			
-			c.Balances[tx.Receiver] += netAmount
-			c.Balances[DeveloperAddress] += fee
-		}
+// SendFrom signs tx as a transaction from w with w's next pending nonce
+// and queues it. It is how the node pays out of its own accounts.
+func (c *Chain) SendFrom(w *wallet.Wallet, tx Transaction) (Transaction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tx.Sender = w.Address
+	tx.Nonce = c.pendingState().Nonce(w.Address)
+	if err := tx.Sign(w, c.Params.ChainID); err != nil {
+		return tx, err
 	}
+	return tx, c.addPending(tx)
+}
 
-	c.Blocks = append(c.Blocks, block)
+func (c *Chain) addPending(tx Transaction) error {
+	if c.Pool == nil {
+		return errors.New("no mempool attached")
+	}
+	if IsSystemSender(tx.Sender) {
+		return fmt.Errorf("transaction %s: block rewards can't be submitted", tx.ID)
+	}
+	if err := tx.VerifySignature(c.Params.ChainID); err != nil {
+		return fmt.Errorf("transaction %s from %s: %w", tx.ID, tx.Sender, err)
+	}
+	if err := c.pendingState().ApplyTransaction(tx, ""); err != nil {
+		return err
+	}
+	if err := c.Pool.Add(tx); err != nil {
+		return err
+	}
+	c.notifyTx(tx)
 	return nil
 }
 
+// PendingNonce returns the next nonce for addr counting its transactions
+// still waiting in the mempool.
+func (c *Chain) PendingNonce(addr string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pendingState().Nonce(addr)
+}
 
-func (c *Chain) MinePendingTransactions(minerAddress string, stakerAddress string, treasuryAddress string) {
-	// Total Reward: 10 ZAR
-	totalReward := 10.0
-	devFee := totalReward * FeePercentage
-	remainingReward := totalReward - devFee
-	
-	minerReward := remainingReward * 0.60
-	stakerReward := remainingReward * 0.30
-	treasuryReward := remainingReward * 0.10
-
-	rewards := []Transaction{
-		{ID: fmt.Sprintf("miner-reward-%d", len(c.Blocks)), Sender: "SYSTEM", Receiver: minerAddress, Amount: minerReward},
-		{ID: fmt.Sprintf("staker-reward-%d", len(c.Blocks)), Sender: "SYSTEM", Receiver: stakerAddress, Amount: stakerReward},
-		{ID: fmt.Sprintf("treasury-reward-%d", len(c.Blocks)), Sender: "SYSTEM", Receiver: treasuryAddress, Amount: treasuryReward},
-		{ID: fmt.Sprintf("dev-fee-%d", len(c.Blocks)), Sender: "SYSTEM", Receiver: DeveloperAddress, Amount: devFee},
+// state returns the committed state, set up to apply the next block. It
+// aliases the chain's maps, so callers must Copy it before applying
+// anything.
+func (c *Chain) state() *State {
+	return &State{
+		Balances:  c.Balances,
+		Nonces:    c.Nonces,
+		Bonds:     c.Bonds,
+		Unbonding: c.Unbonding,
+		Slashes:   c.Slashes,
+		Height:    c.tip().Index + 1,
+		Params:    c.Params,
 	}
-	
-	txs := append(c.Mempool, rewards...)
-	c.Mempool = []Transaction{}
+}
 
-	newBlock := NewBlock(int64(len(c.Blocks)), c.GetLatestBlock().Hash, txs, c.Difficulty)
-	newBlock.Mine()
-	c.AddBlock(newBlock)
+// setState commits st as the chain state.
+func (c *Chain) setState(st *State) {
+	c.Balances, c.Nonces = st.Balances, st.Nonces
+	c.Bonds, c.Unbonding, c.Slashes = st.Bonds, st.Unbonding, st.Slashes
+}
 
-	// Adjust difficulty every 10 blocks (for testing) or 100 for production
-	if len(c.Blocks)%10 == 0 {
-		c.AdjustDifficulty()
+// pendingState returns a scratch copy of the committed state with every
+// currently valid mempool transaction applied in order.
+func (c *Chain) pendingState() *State {
+	st := c.state().Copy()
+	for _, tx := range c.pendingTxs() {
+		st.ApplyTransaction(tx, "")
 	}
-
-	c.SaveToFile()
+	return st
 }
 
-func (c *Chain) AdjustDifficulty() {
+// AddBlock validates block and adds it to the block tree. A block on the
+// current tip extends the main chain; one on any other known block is kept
+// as a side branch and triggers a reorg once its branch has more work.
+func (c *Chain) AddBlock(block *Block) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	// Simple logic: increase difficulty as height grows
-	// To make it professional, compare actual mine time vs target time
-	c.Difficulty++
-	fmt.Printf("[NETWORK] Difficulty increased to: %d\n", c.Difficulty)
-}
+	if c.Engine == nil {
+		return ErrNoEngine
+	}
+	if block.Version < CurrentBlockVersion {
+		return fmt.Errorf("block version %d is no longer accepted", block.Version)
+	}
+	if _, ok := c.byHash[block.Hash]; ok {
+		return ErrKnownBlock
+	}
+	parent, ok := c.byHash[block.PrevHash]
+	if !ok {
+		return fmt.Errorf("%w %s", ErrUnknownParent, block.PrevHash)
+	}
+	if block.Index != parent.Index+1 {
+		return fmt.Errorf("invalid block index %d, expected %d", block.Index, parent.Index+1)
+	}
+	if err := c.checkFinalized(parent); err != nil {
+		return err
+	}
 
+	// The timestamp feeds retargeting, so it can't go backwards or run ahead
+	if block.Timestamp < parent.Timestamp {
+		return fmt.Errorf("block timestamp %d is before its parent's %d", block.Timestamp, parent.Timestamp)
+	}
+	if limit := time.Now().Unix() + c.Params.MaxFutureBlockTime; block.Timestamp > limit {
+		return fmt.Errorf("block timestamp %d is too far in the future", block.Timestamp)
+	}
 
-func (c *Chain) SaveToFile() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	if block.Hash != block.CalculateHash() {
+		return errors.New("invalid block hash")
+	}
+	if err := c.Engine.VerifyHeader(chainReader{c}, block, parent); err != nil {
+		return err
+	}
+	c.checkDoubleSign(block)
+	if err := c.verifyBody(block); err != nil {
+		return err
+	}
+
+	block.ChainWork = new(big.Int).Add(parent.ChainWork, block.Work())
+
+	if parent != c.tip() {
+		return c.addSideBlock(block)
+	}
 
-	data, err := json.MarshalIndent(c, "", "  ")
+	// Run the block against a scratch copy and only commit if every
+	// transaction applies (nonces in sequence, no overdrafts)
+	st := c.state().Copy()
+	if err := applyBlock(st, block, c.Params); err != nil {
+		return err
+	}
+
+	undo := c.state().undoTo(st)
+	c.Undo[block.Hash] = undo
+	if err := c.saveBlocks(block); err != nil {
+		delete(c.Undo, block.Hash)
+		return fmt.Errorf("cannot store block %d: %w", block.Index, err)
+	}
+	c.setState(st)
+	c.Blocks = append(c.Blocks, block)
+	c.byHash[block.Hash] = block
+	c.indexSigner(block)
+	if c.Pool != nil {
+		c.Pool.RemoveIncluded(block.Transactions)
+	}
+	c.updateFinality()
+	err := c.saveState(undo)
+	if err == nil {
+		c.maintain()
+	}
+	c.notifyHead(block)
 	if err != nil {
+		return fmt.Errorf("block %d added but its state was not stored: %w", block.Index, err)
+	}
+	return nil
+}
+
+// verifyBody checks the transactions of a block: its rewards, the
+// transaction root, the size limits and every signature.
+func (c *Chain) verifyBody(block *Block) error {
+	if err := checkRewards(block, c.Engine.Finalize(chainReader{c}, &block.Header)); err != nil {
 		return err
 	}
+	if root := TxRoot(block.Transactions); block.TxRoot != root {
+		return fmt.Errorf("transaction root %s does not match transactions (%s)", block.TxRoot, root)
+	}
 
-	return os.WriteFile("chaindata.json", data, 0644)
+	if len(block.Transactions) > MaxBlockTxs {
+		return fmt.Errorf("block has %d transactions, limit is %d", len(block.Transactions), MaxBlockTxs)
+	}
+	if size := transactionsSize(block.Transactions); size > MaxBlockBytes {
+		return fmt.Errorf("block transactions take %d bytes, limit is %d", size, MaxBlockBytes)
+	}
+
+	// Reject the whole block if any user transfer isn't signed by its
+	// sender or doesn't go to an address
+	for _, tx := range block.Transactions {
+		if err := tx.checkReceiver(); err != nil {
+			return fmt.Errorf("invalid transaction %s from %s: %w", tx.ID, tx.Sender, err)
+		}
+		if err := tx.VerifySignature(c.Params.ChainID); err != nil {
+			return fmt.Errorf("invalid transaction %s from %s: %w", tx.ID, tx.Sender, err)
+		}
+	}
+	return nil
 }
 
-func LoadChain(difficulty int) *Chain {
-	data, err := os.ReadFile("chaindata.json")
+// applyBlock applies the transactions of block to st, settles the end of
+// the block and checks the result against the block's state root. Blocks
+// from before version 3 don't commit to one.
+func applyBlock(st *State, block *Block, p Params) error {
+	st.Height = block.Index
+	if err := st.ApplyTransactions(block.Transactions, block.Coinbase); err != nil {
+		return fmt.Errorf("block %d rejected: %w", block.Index, err)
+	}
+	st.settle(block.Index, p)
+	if block.Version < BlockVersionHeader {
+		return nil
+	}
+	if root := st.Root(); block.StateRoot != root {
+		return fmt.Errorf("block %d rejected: state root %s does not match resulting state (%s)", block.Index, block.StateRoot, root)
+	}
+	return nil
+}
+
+// MinePendingTransactions builds, seals and adds a block on the calling
+// goroutine. The node's background mining goes through pkg/miner instead.
+func (c *Chain) MinePendingTransactions(minerAddress string) {
+	newBlock, err := c.PrepareBlock(minerAddress)
+	if err != nil {
+		fmt.Printf("[MINER] Cannot build block: %v\n", err)
+		return
+	}
+	sealed, err := c.Engine.Seal(context.Background(), newBlock)
 	if err != nil {
-		// If file doesn't exist, return a new chain
-		return NewChain(difficulty)
+		fmt.Printf("[MINER] Cannot seal block %d: %v\n", newBlock.Index, err)
+		return
+	}
+	if err := c.AddBlock(sealed); err != nil {
+		fmt.Printf("[MINER] Block %d rejected: %v\n", sealed.Index, err)
+		return
 	}
+	fmt.Printf("Block Mined! Hash: %s\n", sealed.Hash)
+}
 
-	var chain Chain
-	if err := json.Unmarshal(data, &chain); err != nil {
-		return NewChain(difficulty)
+// PrepareBlock assembles an unsealed block on top of the current tip with
+// the engine's block rewards and the best-paying pending transactions.
+func (c *Chain) PrepareBlock(minerAddress string) (*Block, error) {
+	// Snapshot the tip and state; if another block lands while we mine,
+	// ours ends up on a side branch
+	c.mu.Lock()
+	if c.Engine == nil {
+		c.mu.Unlock()
+		return nil, ErrNoEngine
+	}
+	parent := c.tip()
+	header := Header{
+		Version:   CurrentBlockVersion,
+		Index:     parent.Index + 1,
+		Timestamp: time.Now().Unix(),
+		PrevHash:  parent.Hash,
+		Coinbase:  minerAddress,
+	}
+	if err := c.Engine.Prepare(chainReader{c}, &header, parent); err != nil {
+		c.mu.Unlock()
+		return nil, err
 	}
+	rewards := c.Engine.Finalize(chainReader{c}, &header)
+	st := c.state().Copy()
+	c.mu.Unlock()
 
-	return &chain
+	// Fill the rest of the block with the highest-paying transactions that
+	// apply cleanly; their fees go to the miner as the block's coinbase
+	var txs []Transaction
+	if c.Pool != nil {
+		txs = c.Pool.SelectForBlock(MaxBlockTxs-len(rewards), MaxBlockBytes-transactionsSize(rewards), func(tx Transaction) error {
+			if err := tx.VerifySignature(c.Params.ChainID); err != nil {
+				return err
+			}
+			return st.ApplyTransaction(tx, minerAddress)
+		})
+	}
+	if err := st.ApplyTransactions(rewards, minerAddress); err != nil {
+		return nil, err
+	}
+	txs = append(txs, rewards...)
+	st.settle(header.Index, c.Params)
+
+	header.TxRoot = TxRoot(txs)
+	header.StateRoot = st.Root()
+	newBlock := &Block{Header: header, Transactions: txs}
+	newBlock.Hash = newBlock.CalculateHash()
+	return newBlock, nil
 }
 
+// checkRewards checks that block ends with exactly the engine's reward
+// transactions and has no other transaction from a system sender.
+func checkRewards(block *Block, rewards []Transaction) error {
+	n := len(block.Transactions) - len(rewards)
+	if n < 0 {
+		return fmt.Errorf("block %d is missing its reward transactions", block.Index)
+	}
+	for _, tx := range block.Transactions[:n] {
+		if IsSystemSender(tx.Sender) {
+			return fmt.Errorf("block %d has unexpected reward transaction %s", block.Index, tx.ID)
+		}
+	}
+	for i := range rewards {
+		if string(block.Transactions[n+i].leafHash()) != string(rewards[i].leafHash()) {
+			return fmt.Errorf("block %d has invalid reward transaction %s", block.Index, block.Transactions[n+i].ID)
+		}
+	}
+	return nil
+}
+
+func transactionsSize(txs []Transaction) int {
+	size := 0
+	for i := range txs {
+		size += txs[i].Size()
+	}
+	return size
+}
+
+// LoadChain reads a chain saved as chaindata.json by nodes before the
+// block store, so it can be imported with Persist. A file that can't be
+// read is an error rather than a reason to start over, since that would
+// throw away its history.
+func LoadChain(path string) (*Chain, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parameters missing from older chain data keep their defaults
+	chain := Chain{Params: DefaultParams()}
+	if err := json.Unmarshal(data, &chain); err != nil {
+		return nil, fmt.Errorf("%s is corrupt: %w", path, err)
+	}
+	if len(chain.Blocks) == 0 {
+		return nil, fmt.Errorf("%s holds no blocks", path)
+	}
+	for _, b := range chain.Blocks {
+		if err := checkDifficulty(b); err != nil {
+			return nil, fmt.Errorf("%s is corrupt: %w", path, err)
+		}
+	}
+	// Chain data written before nonces were tracked, or with
+	// checksummed balance keys, is brought up to the current layout
+	st := chain.state()
+	if st.Nonces == nil {
+		st.Nonces = make(map[string]uint64)
+	}
+	st.normalize()
+	chain.setState(st)
+	if chain.Undo == nil {
+		chain.Undo = make(map[string]StateUndo)
+	}
+	if chain.SideBlocks == nil {
+		chain.SideBlocks = make(map[string]*Block)
+	}
+	chain.Params = chain.Params.withDefaults()
+	chain.indexBlocks()
+	if tip := chain.tip(); tip.StateRoot != "" {
+		if root := chain.state().Root(); root != tip.StateRoot {
+			return nil, fmt.Errorf("%s is corrupt: balances do not match block %d", path, tip.Index)
+		}
+	}
+	return &chain, nil
+}
@@ -0,0 +1,596 @@
+package blockchain_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"zar-blockchain/pkg/blockchain"
+	"zar-blockchain/pkg/consensus"
+	"zar-blockchain/pkg/mempool"
+	"zar-blockchain/pkg/storage"
+	"zar-blockchain/pkg/wallet"
+)
+
+const receiver = "0x00000000000000000000000000000000000000aa"
+
+// newTestChain starts a proof-of-work chain at the lowest difficulty in
+// which w holds 100 ZAR.
+func newTestChain(t *testing.T, w *wallet.Wallet) (*blockchain.Chain, consensus.Engine) {
+	t.Helper()
+	g := blockchain.NetworkGenesis(blockchain.Devnet)
+	g.Alloc[w.Address] = blockchain.ZAR(100)
+	c, err := blockchain.NewChainFromGenesis(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := consensus.New(c.Params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetEngine(engine)
+	c.SetTxPool(mempool.New(mempool.DefaultConfig()))
+	return c, engine
+}
+
+// sealWith builds the next block with txs ahead of its rewards and seals
+// it, without checking whether they apply.
+func sealWith(t *testing.T, c *blockchain.Chain, engine consensus.Engine, txs ...blockchain.Transaction) *blockchain.Block {
+	t.Helper()
+	b, err := c.PrepareBlock(receiver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Transactions = append(txs, b.Transactions...)
+	b.TxRoot = blockchain.TxRoot(b.Transactions)
+	b.Hash = b.CalculateHash()
+	sealed, err := engine.Seal(context.Background(), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+func TestAddBlockRejectsMints(t *testing.T) {
+	w, _ := wallet.NewWallet()
+	mint := blockchain.Transaction{ID: "mint", Receiver: receiver, Amount: blockchain.ZAR(1e9)}
+	tests := []struct {
+		sender string
+		err    string
+	}{
+		{"SYSTEM", "unexpected reward transaction"},
+		{"FAUCET", blockchain.ErrMissingSignature.Error()},
+		{"BRIDGE", blockchain.ErrMissingSignature.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sender, func(t *testing.T) {
+			c, engine := newTestChain(t, w)
+			tx := mint
+			tx.Sender = tt.sender
+			if err := c.AddPendingTransaction(tx); err == nil {
+				t.Error("mint from", tt.sender, "entered the mempool")
+			}
+			err := c.AddBlock(sealWith(t, c, engine, tx))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("AddBlock = %v, want an error containing %q", err, tt.err)
+			}
+			if c.Height() != 0 || !c.GetBalance(receiver).IsZero() {
+				t.Errorf("chain at height %d with %s ZAR minted", c.Height(), c.GetBalance(receiver))
+			}
+		})
+	}
+}
+
+func TestAddBlockSizeLimit(t *testing.T) {
+	w, _ := wallet.NewWallet()
+	c, engine := newTestChain(t, w)
+	tx := blockchain.Transaction{ID: "big", Sender: w.Address, Receiver: receiver, Amount: blockchain.ZAR(1)}
+	tx.Signature = strings.Repeat("0", blockchain.MaxBlockBytes)
+	err := c.AddBlock(sealWith(t, c, engine, tx))
+	if err == nil || !strings.Contains(err.Error(), "limit is") {
+		t.Fatalf("AddBlock = %v, want the size limit", err)
+	}
+}
+
+// TestAddBlockRejectsNonHexReceiver checks that transfers can only go to
+// addresses, not to keys naming other entries of the state tree.
+func TestAddBlockRejectsNonHexReceiver(t *testing.T) {
+	w, _ := wallet.NewWallet()
+	c, engine := newTestChain(t, w)
+	tx := blockchain.Transaction{ID: "bond", Sender: w.Address, Receiver: "bond:" + w.Address + "/" + w.Address, Amount: blockchain.ZAR(1)}
+	if err := tx.Sign(w, c.Params.ChainID); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddPendingTransaction(tx); !errors.Is(err, blockchain.ErrInvalidReceiver) {
+		t.Errorf("AddPendingTransaction = %v, want ErrInvalidReceiver", err)
+	}
+	if err := c.AddBlock(sealWith(t, c, engine, tx)); !errors.Is(err, blockchain.ErrInvalidReceiver) {
+		t.Errorf("AddBlock = %v, want ErrInvalidReceiver", err)
+	}
+}
+
+// TestReorg checks that a heavier branch replaces the main chain, the
+// state is rolled back to the fork and forward along the new branch, and
+// transactions only the old branch had go back to the mempool.
+func TestReorg(t *testing.T) {
+	w, _ := wallet.NewWallet()
+	c, engine := newTestChain(t, w)
+	other, otherEngine := newTestChain(t, w)
+
+	tx := blockchain.Transaction{ID: "pay", Sender: w.Address, Receiver: receiver, Amount: blockchain.ZAR(10)}
+	if err := tx.Sign(w, c.Params.ChainID); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddPendingTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddBlock(sealWith(t, c, engine)); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.GetBalance(w.Address); got.Cmp(blockchain.ZAR(90)) != 0 {
+		t.Fatalf("sender holds %s ZAR, want 90", got)
+	}
+
+	var branch []*blockchain.Block
+	for range 2 {
+		b := sealWith(t, other, otherEngine)
+		if err := other.AddBlock(b); err != nil {
+			t.Fatal(err)
+		}
+		branch = append(branch, b)
+	}
+	reorgs := c.SubscribeReorgs()
+	for _, b := range branch {
+		if err := c.AddBlock(b); err != nil {
+			t.Fatalf("AddBlock(%d) = %v", b.Index, err)
+		}
+	}
+
+	if tip := c.GetLatestBlock(); tip.Hash != branch[1].Hash {
+		t.Fatalf("tip is block %d %s, want %s", tip.Index, tip.Hash, branch[1].Hash)
+	}
+	for _, addr := range []string{w.Address, receiver} {
+		if got, want := c.GetBalance(addr), other.GetBalance(addr); got.Cmp(want) != 0 {
+			t.Errorf("%s holds %s ZAR after the reorg, want %s", addr, got, want)
+		}
+	}
+	if n := c.GetNonce(w.Address); n != 0 {
+		t.Errorf("sender nonce is %d after the reorg, want 0", n)
+	}
+	select {
+	case ev := <-reorgs:
+		if len(ev.Dropped) != 1 || len(ev.Added) != 2 || len(ev.Orphaned) != 1 {
+			t.Errorf("reorg dropped %d blocks, added %d and orphaned %d transactions, want 1, 2 and 1", len(ev.Dropped), len(ev.Added), len(ev.Orphaned))
+		}
+	default:
+		t.Error("no reorg event")
+	}
+	if pending := c.Pool.Pending(); len(pending) != 1 || pending[0].Hash() != tx.Hash() {
+		t.Errorf("mempool holds %d transactions, want the orphaned one", len(pending))
+	}
+	if n := c.PendingNonce(w.Address); n != 1 {
+		t.Errorf("pending nonce is %d, want 1", n)
+	}
+}
+
+// TestStakeRejectsNonHexValidator checks that staking transactions get the
+// same receiver check as transfers.
+func TestStakeRejectsNonHexValidator(t *testing.T) {
+	w, _ := wallet.NewWallet()
+	c, _ := newTestChain(t, w)
+	for _, typ := range []string{blockchain.TxStake, blockchain.TxUnstake} {
+		tx := blockchain.Transaction{ID: typ, Type: typ, Sender: w.Address, Receiver: "validator", Amount: blockchain.ZAR(1)}
+		if err := tx.Sign(w, c.Params.ChainID); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.AddPendingTransaction(tx); !errors.Is(err, blockchain.ErrInvalidReceiver) {
+			t.Errorf("%s: AddPendingTransaction = %v, want ErrInvalidReceiver", typ, err)
+		}
+	}
+}
+
+// TestBlockSignatureChainID checks that a validator's block signature
+// can't be replayed on another chain.
+func TestBlockSignatureChainID(t *testing.T) {
+	w, _ := wallet.NewWallet()
+	b := blockchain.NewBlock(1, "0", nil, 0)
+	b.Validator = w.Address
+	b.Hash = b.CalculateHash()
+	if err := b.Sign(w, blockchain.MainnetChainID); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.VerifySignature(blockchain.MainnetChainID); err != nil {
+		t.Errorf("VerifySignature = %v", err)
+	}
+	if err := b.VerifySignature(blockchain.MainnetChainID + 1); !errors.Is(err, blockchain.ErrInvalidBlockSignature) {
+		t.Errorf("VerifySignature on another chain = %v, want ErrInvalidBlockSignature", err)
+	}
+}
+
+// TestHeaderEvidenceChainID checks that two blocks a validator signed on
+// another chain don't get it slashed on this one.
+func TestHeaderEvidenceChainID(t *testing.T) {
+	w, _ := wallet.NewWallet()
+	const chainID = blockchain.MainnetChainID + 1
+	var ev blockchain.DoubleSignEvidence
+	for i, h := range []*blockchain.SignedHeader{&ev.A, &ev.B} {
+		b := blockchain.NewBlock(1, "0", nil, 0)
+		b.Validator = w.Address
+		b.Timestamp += int64(i)
+		b.Hash = b.CalculateHash()
+		if err := b.Sign(w, chainID); err != nil {
+			t.Fatal(err)
+		}
+		*h = blockchain.SignedHeader{Header: b.Header, Signature: b.Signature}
+	}
+	if _, err := ev.Verify(chainID); err != nil {
+		t.Fatalf("Verify = %v", err)
+	}
+	if _, err := ev.Verify(blockchain.MainnetChainID); !errors.Is(err, blockchain.ErrInvalidEvidence) {
+		t.Errorf("Verify on another chain = %v, want ErrInvalidEvidence", err)
+	}
+}
+
+// TestFinality checks that a checkpoint with votes from two thirds of the
+// stake is justified, and is finalized once the next one is justified.
+func TestFinality(t *testing.T) {
+	validators := make([]*wallet.Wallet, 3)
+	g := blockchain.NetworkGenesis(blockchain.Devnet)
+	g.Config.CheckpointInterval = 2
+	g.Config.Validators = make(map[string]blockchain.Amount)
+	for i := range validators {
+		validators[i], _ = wallet.NewWallet()
+		g.Config.Validators[validators[i].Address] = g.Config.MinValidatorStake
+	}
+	c, err := blockchain.NewChainFromGenesis(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := consensus.New(c.Params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetEngine(engine)
+	genesis := c.GetLatestBlock()
+
+	blocks := []*blockchain.Block{genesis}
+	for range 4 {
+		b := sealWith(t, c, engine)
+		if err := c.AddBlock(b); err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, b)
+	}
+	check := func(safe, finalized *blockchain.Block) {
+		t.Helper()
+		if got := c.SafeBlock(); got.Hash != safe.Hash {
+			t.Errorf("safe block is %d, want %d", got.Index, safe.Index)
+		}
+		if got := c.FinalizedBlock(); got.Hash != finalized.Hash {
+			t.Errorf("finalized block is %d, want %d", got.Index, finalized.Index)
+		}
+	}
+
+	if err := c.VoteCheckpoint(blocks[1], validators[0]); !errors.Is(err, blockchain.ErrInvalidVote) {
+		t.Errorf("vote off a checkpoint = %v, want ErrInvalidVote", err)
+	}
+	outsider, _ := wallet.NewWallet()
+	if err := c.VoteCheckpoint(blocks[2], outsider); !errors.Is(err, blockchain.ErrInvalidVote) {
+		t.Errorf("vote by a non-validator = %v, want ErrInvalidVote", err)
+	}
+
+	// A third of the stake isn't enough, two thirds justify the checkpoint
+	if err := c.VoteCheckpoint(blocks[2], validators[0]); err != nil {
+		t.Fatal(err)
+	}
+	check(genesis, genesis)
+	if err := c.VoteCheckpoint(blocks[2], validators[0]); !errors.Is(err, blockchain.ErrKnownVote) {
+		t.Errorf("repeated vote = %v, want ErrKnownVote", err)
+	}
+	if err := c.VoteCheckpoint(blocks[2], validators[1]); err != nil {
+		t.Fatal(err)
+	}
+	// Genesis is final already, so justifying the first checkpoint
+	// finalizes nothing new
+	check(blocks[2], genesis)
+
+	// Justifying the next checkpoint finalizes this one
+	for _, v := range validators[1:] {
+		if err := c.VoteCheckpoint(blocks[4], v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(blocks[4], blocks[2])
+	if err := c.VoteCheckpoint(blocks[2], validators[2]); !errors.Is(err, blockchain.ErrInvalidVote) {
+		t.Errorf("vote for a finalized checkpoint = %v, want ErrInvalidVote", err)
+	}
+
+	// Nothing forking off below the finalized block is accepted
+	other, err := blockchain.NewChainFromGenesis(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.SetEngine(engine)
+	fork, err := other.PrepareBlock(outsider.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fork, err = engine.Seal(context.Background(), fork); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddBlock(fork); !errors.Is(err, blockchain.ErrFinalizedConflict) {
+		t.Errorf("AddBlock of a fork below the finalized block = %v, want ErrFinalizedConflict", err)
+	}
+}
+
+func TestGenesisValidate(t *testing.T) {
+	for _, network := range []string{blockchain.Mainnet, blockchain.Testnet, blockchain.Devnet} {
+		if err := blockchain.NetworkGenesis(network).Validate(); err != nil {
+			t.Errorf("%s genesis: %v", network, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(g *blockchain.Genesis)
+	}{
+		{"chain ID", func(g *blockchain.Genesis) { g.Config.ChainID = 0 }},
+		{"timestamp", func(g *blockchain.Genesis) { g.Timestamp = -1 }},
+		{"engine", func(g *blockchain.Genesis) { g.Config.Engine = "pow2" }},
+		{"validatorless proof of stake", func(g *blockchain.Genesis) { g.Config.Engine = blockchain.EnginePoS }},
+		{"block reward", func(g *blockchain.Genesis) { g.Config.BlockReward = blockchain.ZAR(-1) }},
+		{"halving interval", func(g *blockchain.Genesis) { g.Config.HalvingInterval = -1 }},
+		{"reward shares", func(g *blockchain.Genesis) { g.Config.MinerShare, g.Config.StakerShare = 60, 50 }},
+		{"fee", func(g *blockchain.Genesis) { g.Config.FeeBps = 10001 }},
+		{"fee recipient", func(g *blockchain.Genesis) { g.Config.FeeBps, g.Config.FeeRecipient = 100, "" }},
+		{"alloc address", func(g *blockchain.Genesis) { g.Alloc["treasury"] = blockchain.ZAR(1) }},
+		{"negative alloc", func(g *blockchain.Genesis) { g.Alloc[receiver] = blockchain.ZAR(-1) }},
+		{"validator address", func(g *blockchain.Genesis) {
+			g.Config.Validators = map[string]blockchain.Amount{"validator": g.Config.MinValidatorStake}
+		}},
+		{"validator stake", func(g *blockchain.Genesis) {
+			g.Config.Validators = map[string]blockchain.Amount{receiver: g.Config.MinValidatorStake.Sub(blockchain.ZAR(1))}
+		}},
+	}
+	for _, tt := range tests {
+		g := blockchain.NetworkGenesis(blockchain.Devnet)
+		tt.change(g)
+		if err := g.Validate(); err == nil {
+			t.Errorf("%s: invalid genesis accepted", tt.name)
+		}
+		if _, err := blockchain.NewChainFromGenesis(g); err == nil {
+			t.Errorf("%s: chain started from an invalid genesis", tt.name)
+		}
+	}
+}
+
+// TestGenesisConfigHash checks that nodes running by different rules
+// don't agree on the genesis block.
+func TestGenesisConfigHash(t *testing.T) {
+	g := blockchain.NetworkGenesis(blockchain.Devnet)
+	b := g.ToBlock()
+	if b.ConfigHash != g.Config.Hash() {
+		t.Errorf("genesis commits to config %s, want %s", b.ConfigHash, g.Config.Hash())
+	}
+	if b.Hash != b.CalculateHash() {
+		t.Error("genesis hash doesn't cover its header")
+	}
+
+	changes := []func(p *blockchain.Params){
+		func(p *blockchain.Params) { p.ChainID++ },
+		func(p *blockchain.Params) { p.FeeBps++ },
+		func(p *blockchain.Params) { p.CheckpointInterval++ },
+		func(p *blockchain.Params) { p.BlockReward = p.BlockReward.Add(blockchain.ZAR(1)) },
+	}
+	for i, change := range changes {
+		other := blockchain.NetworkGenesis(blockchain.Devnet)
+		change(&other.Config)
+		if other.ToBlock().Hash == b.Hash {
+			t.Errorf("change %d: genesis hash stayed %s", i, b.Hash)
+		}
+	}
+
+	seen := make(map[string]string)
+	for _, network := range []string{blockchain.Mainnet, blockchain.Testnet, blockchain.Devnet} {
+		hash := blockchain.NetworkGenesis(network).ToBlock().Hash
+		if prev, ok := seen[hash]; ok {
+			t.Errorf("%s and %s share genesis %s", prev, network, hash)
+		}
+		seen[hash] = network
+	}
+}
+
+// TestVerifyTamperedState checks that Verify finds stored state that the
+// blocks don't add up to, and that RebuildState puts it right.
+func TestVerifyTamperedState(t *testing.T) {
+	w, _ := wallet.NewWallet()
+	g := blockchain.NetworkGenesis(blockchain.Devnet)
+	g.Alloc[w.Address] = blockchain.ZAR(100)
+	blocks, state := storage.NewMemory(), storage.NewMemory()
+	open := func() (*blockchain.Chain, error) {
+		t.Helper()
+		c, err := blockchain.OpenChain(blocks, state, g)
+		if c == nil {
+			t.Fatal(err)
+		}
+		engine, engineErr := consensus.New(c.Params, nil)
+		if engineErr != nil {
+			t.Fatal(engineErr)
+		}
+		c.SetEngine(engine)
+		c.SetTxPool(mempool.New(mempool.DefaultConfig()))
+		return c, err
+	}
+
+	c, err := open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.SendFrom(w, blockchain.Transaction{ID: "pay", Receiver: receiver, Amount: blockchain.ZAR(10)}); err != nil {
+		t.Fatal(err)
+	}
+	c.MinePendingTransactions(receiver)
+	want := c.GetBalance(w.Address)
+	if c.Height() != 1 || want.Cmp(blockchain.ZAR(90)) != 0 {
+		t.Fatalf("chain at height %d with %s ZAR left to the sender", c.Height(), want)
+	}
+
+	// Give the sender money no block gave it
+	batch := state.NewBatch()
+	acc, _ := json.Marshal(blockchain.Account{Balance: blockchain.ZAR(1000), Nonce: 1})
+	batch.Put([]byte("a"+strings.ToLower(w.Address)), acc)
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = open()
+	if !errors.Is(err, blockchain.ErrStateRootMismatch) {
+		t.Fatalf("OpenChain = %v, want ErrStateRootMismatch", err)
+	}
+	r, err := c.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Divergent != nil {
+		t.Fatalf("block %d failed: %v", r.Divergent.Index, r.Err)
+	}
+	if r.OK() || len(r.Mismatches) != 1 || !strings.Contains(r.Mismatches[0], strings.ToLower(w.Address)) {
+		t.Fatalf("Verify found mismatches %q, want the sender's balance", r.Mismatches)
+	}
+	if err := c.RebuildState(r); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = open()
+	if err != nil {
+		t.Fatalf("OpenChain after RebuildState = %v", err)
+	}
+	if got := c.GetBalance(w.Address); got.Cmp(want) != 0 {
+		t.Errorf("sender holds %s ZAR after RebuildState, want %s", got, want)
+	}
+	if r, err := c.Verify(); err != nil || !r.OK() {
+		t.Errorf("Verify after RebuildState = %+v, %v", r, err)
+	}
+}
+
+// snapshotChain mines three blocks on a devnet chain that snapshots every
+// second block and returns its genesis and the snapshot file of block 2.
+func snapshotChain(t *testing.T) (*blockchain.Genesis, []byte) {
+	t.Helper()
+	g := blockchain.NetworkGenesis(blockchain.Devnet)
+	c, err := blockchain.OpenChain(storage.NewMemory(), storage.NewMemory(), g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := consensus.New(c.Params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetEngine(engine)
+	c.SetSnapshots(blockchain.SnapshotConfig{Interval: 2})
+	for i := 0; i < 3; i++ {
+		c.MinePendingTransactions(receiver)
+	}
+	var buf bytes.Buffer
+	if _, err := c.ExportSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return g, buf.Bytes()
+}
+
+func TestImportSnapshot(t *testing.T) {
+	g, file := snapshotChain(t)
+	engine, err := consensus.New(g.Config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type snapshotFile struct {
+		Headers  []*blockchain.Block `json:"headers"`
+		Snapshot json.RawMessage     `json:"snapshot"`
+		Blocks   []*blockchain.Block `json:"blocks"`
+	}
+	var f snapshotFile
+	if err := json.Unmarshal(file, &f); err != nil {
+		t.Fatal(err)
+	}
+	// tamper returns the file with its snapshot block edited
+	tamper := func(edit func(base *blockchain.Block)) []byte {
+		var copied snapshotFile
+		json.Unmarshal(file, &copied)
+		edit(copied.Headers[len(copied.Headers)-1])
+		data, _ := json.Marshal(copied)
+		return data
+	}
+
+	tests := []struct {
+		name    string
+		file    []byte
+		trusted string
+		err     string
+	}{
+		{"valid", file, "", ""},
+		{"trusted snapshot block", file, f.Headers[2].Hash, ""},
+		{"trusted later block", file, "0x" + f.Blocks[0].Hash, ""},
+		{"untrusted block", file, f.Headers[1].Hash, "trusted block"},
+		{"broken link", tamper(func(b *blockchain.Block) {
+			b.PrevHash = f.Headers[0].Hash
+		}), "", "header 2: block does not link"},
+		{"target not retargeted", tamper(func(b *blockchain.Block) {
+			// A harder target than the chain asks for, met by its own hash
+			b.Bits = blockchain.DifficultyToBits(2)
+			sealed, err := engine.Seal(context.Background(), b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			*b = *sealed
+		}), "", "header 2: invalid target bits"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, next, err := blockchain.ImportSnapshot(bytes.NewReader(tt.file), g, engine, tt.trusted, storage.NewMemory(), storage.NewMemory())
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("ImportSnapshot = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, b := range next {
+				if err := c.AddBlock(b); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if c.Height() != 3 {
+				t.Errorf("imported chain at height %d, want 3", c.Height())
+			}
+		})
+	}
+
+	pos := blockchain.NetworkGenesis(blockchain.Devnet)
+	pos.Config.Engine = blockchain.EnginePoS
+	pos.Config.Validators = map[string]blockchain.Amount{receiver: pos.Config.MinValidatorStake}
+	if _, _, err := blockchain.ImportSnapshot(bytes.NewReader(file), pos, engine, "", storage.NewMemory(), storage.NewMemory()); err == nil || !strings.Contains(err.Error(), "trusted block hash") {
+		t.Errorf("ImportSnapshot of proof of stake without a trusted hash = %v", err)
+	}
+}
+
+func TestOpenChainPrunedAboveHead(t *testing.T) {
+	blocks, state := storage.NewMemory(), storage.NewMemory()
+	g := blockchain.NetworkGenesis(blockchain.Devnet)
+	if _, err := blockchain.OpenChain(blocks, state, g); err != nil {
+		t.Fatal(err)
+	}
+	batch := blocks.NewBatch()
+	batch.Put([]byte("mpruned"), []byte("5"))
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blockchain.OpenChain(blocks, state, g); !errors.Is(err, blockchain.ErrCorrupt) {
+		t.Fatalf("OpenChain = %v, want ErrCorrupt", err)
+	}
+}
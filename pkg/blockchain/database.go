@@ -0,0 +1,27 @@
+package blockchain
+
+import "errors"
+
+var ErrNotFound = errors.New("key not found")
+
+// Database is the key-value store the chain persists to. Writes go
+// through batches, which apply atomically. pkg/storage has the on-disk
+// and in-memory implementations.
+type Database interface {
+	// Get returns the value of key, or ErrNotFound.
+	Get(key []byte) ([]byte, error)
+	Has(key []byte) (bool, error)
+	// Iterate calls fn for every key with prefix in key order. The slices
+	// are only valid during the call.
+	Iterate(prefix []byte, fn func(key, value []byte) error) error
+	NewBatch() Batch
+	Close() error
+}
+
+// Batch collects writes to apply to a Database at once.
+type Batch interface {
+	Put(key, value []byte)
+	Delete(key []byte)
+	// Write applies the batch: either every write lands or none does.
+	Write() error
+}
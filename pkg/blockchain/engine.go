@@ -0,0 +1,49 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrNoEngine = errors.New("no consensus engine attached")
+
+// Engine is a consensus algorithm. The chain drives it to build, seal and
+// validate blocks; pkg/consensus has the proof-of-work and proof-of-stake
+// implementations.
+type Engine interface {
+	// Prepare sets the consensus fields of a new header on top of parent.
+	Prepare(chain ChainReader, header *Header, parent *Block) error
+	// Seal returns a copy of block that satisfies the consensus rules,
+	// blocking until it does or ctx is cancelled.
+	Seal(ctx context.Context, block *Block) (*Block, error)
+	// VerifyHeader checks the consensus fields and seal of block against
+	// its parent.
+	VerifyHeader(chain ChainReader, block *Block, parent *Block) error
+	// Finalize returns the reward transactions a block with header must
+	// end with.
+	Finalize(chain ChainReader, header *Header) []Transaction
+}
+
+// ChainReader is the read access engines get to the chain. Engines are
+// called with the chain lock held, so it must not be used afterwards.
+type ChainReader interface {
+	Config() Params
+	GetBlock(hash string) *Block
+	// StateAt returns the state after block, which may be on a side
+	// branch. The state must not be modified.
+	StateAt(block *Block) (*State, error)
+}
+
+type chainReader struct{ c *Chain }
+
+func (r chainReader) Config() Params                       { return r.c.Params }
+func (r chainReader) GetBlock(hash string) *Block          { return r.c.byHash[hash] }
+func (r chainReader) StateAt(block *Block) (*State, error) { return r.c.stateAfter(block) }
+
+// SetEngine attaches the consensus engine used to build and validate
+// blocks.
+func (c *Chain) SetEngine(engine Engine) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Engine = engine
+}
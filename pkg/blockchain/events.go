@@ -0,0 +1,101 @@
+package blockchain
+
+// Subscribers are notified while the chain lock is held, so sends never
+// block: a subscriber that falls behind misses events rather than
+// stalling block import. Channels are buffered to make that rare.
+const subscriberBuffer = 16
+
+// SubscribeHeads returns a channel that receives every block added to the
+// chain.
+func (c *Chain) SubscribeHeads() <-chan *Block {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan *Block, subscriberBuffer)
+	c.headSubs = append(c.headSubs, ch)
+	return ch
+}
+
+// SubscribeTxs returns a channel that receives every transaction accepted
+// into the mempool.
+func (c *Chain) SubscribeTxs() <-chan Transaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan Transaction, subscriberBuffer)
+	c.txSubs = append(c.txSubs, ch)
+	return ch
+}
+
+func (c *Chain) notifyHead(b *Block) {
+	for _, ch := range c.headSubs {
+		select {
+		case ch <- b:
+		default:
+		}
+	}
+}
+
+func (c *Chain) notifyTx(tx Transaction) {
+	for _, ch := range c.txSubs {
+		select {
+		case ch <- tx:
+		default:
+		}
+	}
+}
+
+// SubscribeReorgs returns a channel that receives an event every time the
+// main chain switches to a heavier branch.
+func (c *Chain) SubscribeReorgs() <-chan *ReorgEvent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan *ReorgEvent, subscriberBuffer)
+	c.reorgSubs = append(c.reorgSubs, ch)
+	return ch
+}
+
+func (c *Chain) notifyReorg(ev *ReorgEvent) {
+	for _, ch := range c.reorgSubs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// SubscribeEvidence returns a channel that receives proof of every double
+// sign seen by the chain, for the node to report.
+func (c *Chain) SubscribeEvidence() <-chan *DoubleSignEvidence {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan *DoubleSignEvidence, subscriberBuffer)
+	c.evidenceSubs = append(c.evidenceSubs, ch)
+	return ch
+}
+
+func (c *Chain) notifyEvidence(ev *DoubleSignEvidence) {
+	for _, ch := range c.evidenceSubs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// SubscribeFinalized returns a channel that receives every checkpoint as
+// it is finalized.
+func (c *Chain) SubscribeFinalized() <-chan *Block {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan *Block, subscriberBuffer)
+	c.finalSubs = append(c.finalSubs, ch)
+	return ch
+}
+
+func (c *Chain) notifyFinalized(b *Block) {
+	for _, ch := range c.finalSubs {
+		select {
+		case ch <- b:
+		default:
+		}
+	}
+}
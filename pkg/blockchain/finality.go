@@ -0,0 +1,265 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"zar-blockchain/pkg/wallet"
+)
+
+// Finality works on checkpoints, the main chain blocks at every multiple
+// of CheckpointInterval. Validators sign a vote for the checkpoint they
+// see, and nodes pass them on over RPC (zar_submitVote). A checkpoint with
+// votes from two thirds of the stake in its validator set is justified,
+// and a justified checkpoint whose next checkpoint is justified too is
+// finalized. The chain never reorgs past the finalized checkpoint.
+//
+// As a validator votes at most once per height, two conflicting
+// checkpoints can only both be justified if a third of the stake signed
+// both, so a finalized block stays final unless that much stake is
+// willing to equivocate. A second vote is rejected and reported as
+// evidence, so equivocating gets that stake slashed like signing two
+// blocks does.
+
+var (
+	ErrInvalidVote       = errors.New("invalid checkpoint vote")
+	ErrKnownVote         = errors.New("vote already known")
+	ErrConflictingVote   = errors.New("validator already voted for another block at this height")
+	ErrFinalizedConflict = errors.New("block conflicts with the finalized chain")
+)
+
+// Vote is a validator's signed vote for the block at a checkpoint height.
+type Vote struct {
+	Height    int64  `json:"height"`
+	Hash      string `json:"hash"`
+	Validator string `json:"validator"`
+	Signature string `json:"signature"`
+}
+
+// SigningPayload returns the bytes the validator signs, bound to the
+// chain ID like transactions.
+func (v *Vote) SigningPayload(chainID int64) []byte {
+	data, _ := json.Marshal(struct {
+		ChainID int64  `json:"chain_id"`
+		Type    string `json:"type"`
+		Height  int64  `json:"height"`
+		Hash    string `json:"hash"`
+	}{chainID, "checkpoint", v.Height, v.Hash})
+	return data
+}
+
+// Sign signs the vote with w for the chain chainID. w must be the vote's
+// validator.
+func (v *Vote) Sign(w *wallet.Wallet, chainID int64) error {
+	if !wallet.SameAddress(v.Validator, w.Address) {
+		return fmt.Errorf("wallet %s cannot sign for validator %s", w.Address, v.Validator)
+	}
+	sig, err := w.Sign(v.SigningPayload(chainID))
+	if err != nil {
+		return err
+	}
+	v.Signature = sig
+	return nil
+}
+
+// Verify checks that the vote was signed by its validator for the chain
+// chainID.
+func (v *Vote) Verify(chainID int64) error {
+	if v.Signature == "" || !wallet.VerifySignature(v.Validator, v.SigningPayload(chainID), v.Signature) {
+		return fmt.Errorf("%w: bad signature", ErrInvalidVote)
+	}
+	return nil
+}
+
+func voteKey(height int64, validator string) string {
+	return strconv.FormatInt(height, 10) + "/" + accountKey(validator)
+}
+
+func (c *Chain) isCheckpoint(height int64) bool {
+	return height%c.Params.CheckpointInterval == 0
+}
+
+// finalized returns the latest finalized checkpoint, genesis if none.
+func (c *Chain) finalized() *Block {
+	if b, ok := c.byHash[c.Finalized]; ok {
+		return b
+	}
+	return c.Blocks[0]
+}
+
+// safe returns the latest justified checkpoint on the main chain.
+func (c *Chain) safe() *Block {
+	if b, ok := c.byHash[c.Safe]; ok && c.isMain(b) {
+		return b
+	}
+	return c.finalized()
+}
+
+// FinalizedBlock returns the latest finalized block.
+func (c *Chain) FinalizedBlock() *Block {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.finalized()
+}
+
+// SafeBlock returns the latest justified block, which only a third of the
+// stake equivocating could revert.
+func (c *Chain) SafeBlock() *Block {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.safe()
+}
+
+// checkFinalized checks that a block on parent doesn't fork off below the
+// finalized checkpoint.
+func (c *Chain) checkFinalized(parent *Block) error {
+	fin := c.finalized()
+	b := parent
+	for !c.isMain(b) {
+		if b = c.byHash[b.PrevHash]; b == nil {
+			return ErrUnknownParent
+		}
+	}
+	if b.Index < fin.Index {
+		return fmt.Errorf("%w: forks at block %d, finalized up to %d", ErrFinalizedConflict, b.Index, fin.Index)
+	}
+	return nil
+}
+
+// AddVote verifies a checkpoint vote and counts it towards finality. Only
+// votes by validators of the checkpoint's validator set for known blocks
+// are kept.
+func (c *Chain) AddVote(v Vote) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := v.Verify(c.Params.ChainID); err != nil {
+		return err
+	}
+	if v.Height <= 0 || !c.isCheckpoint(v.Height) {
+		return fmt.Errorf("%w: block %d is not a checkpoint", ErrInvalidVote, v.Height)
+	}
+	if fin := c.finalized(); v.Height <= fin.Index {
+		return fmt.Errorf("%w: block %d is already final", ErrInvalidVote, v.Height)
+	}
+	b, ok := c.byHash[v.Hash]
+	if !ok || b.Index != v.Height {
+		return fmt.Errorf("%w: no block %s at height %d", ErrInvalidVote, v.Hash, v.Height)
+	}
+
+	key := voteKey(v.Height, v.Validator)
+	if prev, ok := c.Votes[key]; ok {
+		if prev.Hash == v.Hash {
+			return ErrKnownVote
+		}
+		fmt.Printf("[STAKING] Validator %s voted for two blocks at height %d\n", v.Validator, v.Height)
+		c.notifyEvidence(&DoubleSignEvidence{Votes: []Vote{prev, v}})
+		return fmt.Errorf("%w: %s voted for %s and %s", ErrConflictingVote, v.Validator, prev.Hash, v.Hash)
+	}
+	voters, err := c.voters(b)
+	if err != nil {
+		return err
+	}
+	if _, ok := voters[accountKey(v.Validator)]; !ok {
+		return fmt.Errorf("%w: %s is not a validator at block %d", ErrInvalidVote, v.Validator, v.Height)
+	}
+
+	if c.Votes == nil {
+		c.Votes = make(map[string]Vote)
+	}
+	c.Votes[key] = v
+	c.updateFinality()
+	return c.saveState()
+}
+
+// VoteCheckpoint signs a vote for checkpoint b with w and adds it. A
+// validator that already voted at b's height gets ErrConflictingVote, so
+// it never equivocates.
+func (c *Chain) VoteCheckpoint(b *Block, w *wallet.Wallet) error {
+	v := Vote{Height: b.Index, Hash: b.Hash, Validator: w.Address}
+	if err := v.Sign(w, c.Params.ChainID); err != nil {
+		return err
+	}
+	return c.AddVote(v)
+}
+
+// voters returns the voting power per validator for checkpoint b: the
+// validator set elected after it.
+func (c *Chain) voters(b *Block) (map[string]Amount, error) {
+	if set, ok := c.voterSets[b.Hash]; ok {
+		return set, nil
+	}
+	st, err := c.stateAfter(b)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]Amount)
+	for _, v := range st.Validators(c.Params.MinValidatorStake) {
+		set[v.Address] = v.Power
+	}
+	if c.voterSets == nil {
+		c.voterSets = make(map[string]map[string]Amount)
+	}
+	c.voterSets[b.Hash] = set
+	return set, nil
+}
+
+// justified reports whether two thirds of the stake voted for checkpoint b.
+func (c *Chain) justified(b *Block) bool {
+	voters, err := c.voters(b)
+	if err != nil {
+		return false
+	}
+	var total, voted Amount
+	for addr, power := range voters {
+		total = total.Add(power)
+		if v, ok := c.Votes[voteKey(b.Index, addr)]; ok && v.Hash == b.Hash {
+			voted = voted.Add(power)
+		}
+	}
+	return !total.IsZero() && voted.MulFrac(3, 1).Cmp(total.MulFrac(2, 1)) >= 0
+}
+
+// updateFinality recomputes the safe and finalized checkpoints of the main
+// chain from the votes. Votes at or below the finalized checkpoint are no
+// longer needed and are dropped.
+func (c *Chain) updateFinality() {
+	fin := c.finalized()
+	if len(c.Votes) == 0 {
+		c.Safe = fin.Hash
+		return
+	}
+	safe, newFin := fin, fin
+	prevJustified := true // The finalized checkpoint counts as justified
+	for h := fin.Index + c.Params.CheckpointInterval; h <= c.tip().Index; h += c.Params.CheckpointInterval {
+		b := c.Blocks[h]
+		if !c.justified(b) {
+			prevJustified = false
+			continue
+		}
+		if prevJustified {
+			newFin = c.Blocks[h-c.Params.CheckpointInterval]
+		}
+		safe, prevJustified = b, true
+	}
+	c.Safe = safe.Hash
+	if newFin == fin {
+		return
+	}
+
+	c.Finalized = newFin.Hash
+	for key, v := range c.Votes {
+		if v.Height <= newFin.Index {
+			delete(c.Votes, key)
+		}
+	}
+	for hash := range c.voterSets {
+		if b, ok := c.byHash[hash]; !ok || b.Index <= newFin.Index {
+			delete(c.voterSets, hash)
+		}
+	}
+	fmt.Printf("[FINALITY] Finalized block %d (%s)\n", newFin.Index, newFin.Hash)
+	c.notifyFinalized(newFin)
+}
@@ -0,0 +1,251 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+var (
+	ErrKnownBlock    = errors.New("block already known")
+	ErrUnknownParent = errors.New("unknown parent block")
+)
+
+// ReorgEvent describes a switch of the main chain to a heavier branch.
+type ReorgEvent struct {
+	Fork     *Block        // Last block shared by both branches
+	OldTip   *Block        // Tip before the reorg
+	NewTip   *Block        // Tip after the reorg
+	Dropped  []*Block      // Blocks that left the main chain, oldest first
+	Added    []*Block      // Blocks that joined the main chain, oldest first
+	Orphaned []Transaction // Transactions of dropped blocks missing from the new branch
+}
+
+// indexBlocks rebuilds the hash index and the cumulative work of every
+// block. Work is derived data, so it is recomputed on load rather than
+// trusted from disk, which also fills it in for chains saved before it
+// was tracked. Side blocks whose parent is unknown are dropped.
+func (c *Chain) indexBlocks() {
+	c.byHash = make(map[string]*Block, len(c.Blocks)+len(c.SideBlocks))
+	c.signers = make(map[string]*Block)
+	total := new(big.Int)
+	for _, b := range c.Blocks {
+		total.Add(total, b.Work())
+		b.ChainWork = new(big.Int).Set(total)
+		c.byHash[b.Hash] = b
+		c.indexSigner(b)
+	}
+
+	side := make([]*Block, 0, len(c.SideBlocks))
+	for _, b := range c.SideBlocks {
+		side = append(side, b)
+	}
+	sort.Slice(side, func(i, j int) bool { return side[i].Index < side[j].Index })
+	for _, b := range side {
+		parent, ok := c.byHash[b.PrevHash]
+		if !ok {
+			delete(c.SideBlocks, b.Hash)
+			continue
+		}
+		b.ChainWork = new(big.Int).Add(parent.ChainWork, b.Work())
+		c.byHash[b.Hash] = b
+		c.indexSigner(b)
+	}
+}
+
+// isMain reports whether b is on the main chain.
+func (c *Chain) isMain(b *Block) bool {
+	return b.Index < int64(len(c.Blocks)) && c.Blocks[b.Index] == b
+}
+
+// addSideBlock stores a block that doesn't extend the tip and reorgs to
+// it if its branch now has the most work.
+func (c *Chain) addSideBlock(block *Block) error {
+	if err := c.saveBlocks(block); err != nil {
+		return fmt.Errorf("cannot store block %d: %w", block.Index, err)
+	}
+	c.byHash[block.Hash] = block
+	c.indexSigner(block)
+	c.SideBlocks[block.Hash] = block
+	if block.ChainWork.Cmp(c.tip().ChainWork) <= 0 {
+		fmt.Printf("[CHAIN] Stored side block %d (%s)\n", block.Index, block.Hash)
+		return nil
+	}
+	return c.reorg(block)
+}
+
+// reorg makes the branch ending at newTip the main chain. The state is
+// rolled back to the fork point with the undo data of the dropped blocks
+// and the new branch is applied on top; if any of its blocks fails, that
+// block and its descendants are discarded and the chain is left as it was.
+func (c *Chain) reorg(newTip *Block) error {
+	var added []*Block
+	b := newTip
+	for !c.isMain(b) {
+		added = append(added, b)
+		b = c.byHash[b.PrevHash]
+	}
+	fork := b
+	if fin := c.finalized(); fork.Index < fin.Index {
+		return fmt.Errorf("%w: reorg to %s forks at block %d", ErrFinalizedConflict, newTip.Hash, fork.Index)
+	}
+	for i, j := 0, len(added)-1; i < j; i, j = i+1, j-1 {
+		added[i], added[j] = added[j], added[i]
+	}
+	dropped := append([]*Block(nil), c.Blocks[fork.Index+1:]...)
+
+	st := c.state().Copy()
+	for i := len(dropped) - 1; i >= 0; i-- {
+		undo, ok := c.Undo[dropped[i].Hash]
+		if !ok {
+			return fmt.Errorf("cannot reorg past block %d: no undo data", dropped[i].Index)
+		}
+		st.Revert(undo)
+	}
+
+	undos := make([]StateUndo, len(added))
+	for i, b := range added {
+		prev := st.Copy()
+		if err := applyBlock(st, b, c.Params); err != nil {
+			c.discard(b)
+			return fmt.Errorf("reorg to %s aborted: %w", newTip.Hash, err)
+		}
+		undos[i] = prev.undoTo(st)
+	}
+
+	for i, b := range added {
+		c.Undo[b.Hash] = undos[i]
+	}
+	if err := c.saveBlocks(added...); err != nil {
+		for _, b := range added {
+			delete(c.Undo, b.Hash)
+		}
+		return fmt.Errorf("reorg to %s aborted: %w", newTip.Hash, err)
+	}
+
+	oldTip := c.tip()
+	for _, b := range added {
+		delete(c.SideBlocks, b.Hash)
+	}
+	for _, b := range dropped {
+		c.SideBlocks[b.Hash] = b
+	}
+	c.Blocks = append(c.Blocks[:fork.Index+1:fork.Index+1], added...)
+	c.setState(st)
+
+	ev := &ReorgEvent{Fork: fork, OldTip: oldTip, NewTip: newTip, Dropped: dropped, Added: added}
+	ev.Orphaned = c.requeue(dropped, added)
+	fmt.Printf("[CHAIN] Reorg at block %d: dropped %d blocks, added %d, new tip %d (%s)\n",
+		fork.Index, len(dropped), len(added), newTip.Index, newTip.Hash)
+	c.updateFinality()
+	// The state changed in every entry the dropped and added blocks touched
+	var changed []StateUndo
+	for _, b := range append(dropped, added...) {
+		changed = append(changed, c.Undo[b.Hash])
+	}
+	err := c.saveState(changed...)
+	if err == nil {
+		c.maintain()
+	}
+	c.notifyReorg(ev)
+	c.notifyHead(newTip)
+	if err != nil {
+		return fmt.Errorf("reorg to %s done but its state was not stored: %w", newTip.Hash, err)
+	}
+	return nil
+}
+
+// stateAfter returns the state after b. For a side block, the main chain
+// state at the fork is rebuilt and the branch is applied on top of it.
+func (c *Chain) stateAfter(b *Block) (*State, error) {
+	if b == c.tip() {
+		return c.state(), nil
+	}
+	height := b.Index + 1
+	var branch []*Block
+	for !c.isMain(b) {
+		branch = append(branch, b)
+		if b = c.byHash[b.PrevHash]; b == nil {
+			return nil, ErrUnknownParent
+		}
+	}
+	st, err := c.stateAt(b.Index)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(branch) - 1; i >= 0; i-- {
+		if err := applyBlock(st, branch[i], c.Params); err != nil {
+			return nil, err
+		}
+	}
+	st.Height = height
+	return st, nil
+}
+
+// discard forgets an invalid side block and every block built on it.
+func (c *Chain) discard(bad *Block) {
+	invalid := map[string]bool{bad.Hash: true}
+	side := make([]*Block, 0, len(c.SideBlocks))
+	for _, b := range c.SideBlocks {
+		side = append(side, b)
+	}
+	sort.Slice(side, func(i, j int) bool { return side[i].Index < side[j].Index })
+	for _, b := range side {
+		if invalid[b.PrevHash] {
+			invalid[b.Hash] = true
+		}
+	}
+	hashes := make([]string, 0, len(invalid))
+	for hash := range invalid {
+		if _, ok := c.SideBlocks[hash]; ok {
+			hashes = append(hashes, hash)
+		}
+	}
+	// Left in the store, they would be replayed again on every start
+	if err := c.deleteBlocks(hashes); err != nil {
+		fmt.Printf("[CHAIN] Cannot remove invalid blocks from the store: %v\n", err)
+	}
+	for _, hash := range hashes {
+		c.unindexSigner(c.SideBlocks[hash])
+		delete(c.SideBlocks, hash)
+		delete(c.byHash, hash)
+	}
+}
+
+// requeue returns the transactions of dropped blocks that the new branch
+// doesn't include to the mempool, as far as they still apply. Block
+// rewards belong to the dropped blocks and are not requeued.
+func (c *Chain) requeue(dropped, added []*Block) []Transaction {
+	included := make(map[string]bool)
+	for _, b := range added {
+		for _, tx := range b.Transactions {
+			included[tx.Hash()] = true
+		}
+	}
+	var orphaned []Transaction
+	for _, b := range dropped {
+		for _, tx := range b.Transactions {
+			if IsSystemSender(tx.Sender) || included[tx.Hash()] {
+				continue
+			}
+			orphaned = append(orphaned, tx)
+		}
+	}
+
+	if c.Pool == nil {
+		return orphaned
+	}
+	for _, b := range added {
+		c.Pool.RemoveIncluded(b.Transactions)
+	}
+	st := c.pendingState()
+	for _, tx := range orphaned {
+		if err := st.ApplyTransaction(tx, ""); err != nil {
+			fmt.Printf("[CHAIN] Orphaned transaction %s no longer applies: %v\n", tx.ID, err)
+			continue
+		}
+		c.Pool.Add(tx)
+	}
+	return orphaned
+}
@@ -0,0 +1,188 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Genesis describes the first block of a chain and the rules the chain
+// runs by. Everything in the genesis block is derived from it, so nodes
+// starting from the same file agree on the genesis hash.
+type Genesis struct {
+	Timestamp int64             `json:"timestamp"`
+	Alloc     map[string]Amount `json:"alloc,omitempty"` // Initial balances
+	// Bits is the initial proof-of-work target in compact form,
+	// Config.PowLimitBits if zero. Proof-of-stake chains ignore it.
+	Bits   uint32 `json:"bits,omitempty"`
+	Config Params `json:"config"`
+}
+
+// mainnetGenesisTime is the timestamp of the original ZAR genesis block.
+const mainnetGenesisTime = 1771953450
+
+// legacyGenesisHash is the genesis block of the main network chain nodes
+// kept in chaindata.json, from before its genesis was described by a
+// Genesis. That chain runs by DefaultParams.
+const legacyGenesisHash = "6c52dbb8d39827c78cea8a7d09ec80a3c3f2e264078f865e9c025a941d73ef09"
+
+// DefaultGenesis returns the genesis of the ZAR main network.
+func DefaultGenesis() *Genesis {
+	return &Genesis{
+		Timestamp: mainnetGenesisTime,
+		Bits:      DifficultyToBits(2),
+		Config:    DefaultParams(),
+	}
+}
+
+// Built-in networks, which NetworkGenesis knows the genesis of.
+const (
+	Mainnet = "mainnet"
+	Testnet = "testnet"
+	Devnet  = "devnet"
+)
+
+const (
+	TestnetChainID = 1958
+	DevnetChainID  = 1959
+)
+
+// NetworkGenesis returns the genesis of a built-in network, or nil for
+// any other name. The test network runs the main network's rules under
+// its own chain ID; the development network mines at the lowest
+// difficulty and starts with funds at the fee recipient to test with.
+func NetworkGenesis(network string) *Genesis {
+	switch network {
+	case Mainnet:
+		return DefaultGenesis()
+	case Testnet:
+		g := DefaultGenesis()
+		g.Timestamp = 1775000000
+		g.Config.ChainID = TestnetChainID
+		return g
+	case Devnet:
+		g := &Genesis{Timestamp: 1775000000, Config: DefaultParams()}
+		g.Config.ChainID = DevnetChainID
+		g.Alloc = map[string]Amount{g.Config.FeeRecipient: ZAR(1000000)}
+		return g
+	}
+	return nil
+}
+
+// LoadGenesis reads a genesis file. Parameters the file leaves out keep
+// their DefaultParams value.
+func LoadGenesis(path string) (*Genesis, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	g := &Genesis{Config: DefaultParams()}
+	if err := json.Unmarshal(data, g); err != nil {
+		return nil, fmt.Errorf("invalid genesis file %s: %w", path, err)
+	}
+	if err := g.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid genesis file %s: %w", path, err)
+	}
+	return g, nil
+}
+
+// Validate checks that the genesis describes a chain that can run.
+func (g *Genesis) Validate() error {
+	p := g.Config
+	switch {
+	case p.ChainID <= 0:
+		return errors.New("chain_id must be positive")
+	case g.Timestamp < 0:
+		return errors.New("timestamp must not be negative")
+	case p.Engine != EnginePoW && p.Engine != EnginePoS:
+		return fmt.Errorf("unknown consensus engine %q", p.Engine)
+	case p.Engine == EnginePoS && len(p.Validators) == 0:
+		return errors.New("proof-of-stake chains need genesis validators")
+	case p.BlockReward.Sign() < 0:
+		return errors.New("block_reward must not be negative")
+	case p.HalvingInterval < 0:
+		return errors.New("halving_interval must not be negative")
+	case p.MinerShare < 0 || p.StakerShare < 0 || p.MinerShare+p.StakerShare > 100:
+		return fmt.Errorf("reward shares %d%% and %d%% must add up to at most 100%%", p.MinerShare, p.StakerShare)
+	case p.FeeBps < 0 || p.FeeBps > 10000:
+		return fmt.Errorf("fee_bps %d is out of range", p.FeeBps)
+	case p.FeeBps > 0 && p.FeeRecipient == "":
+		return errors.New("fee_recipient is required with a fee")
+	}
+	for addr, balance := range g.Alloc {
+		if !common.IsHexAddress(addr) {
+			return fmt.Errorf("invalid alloc address %q", addr)
+		}
+		if balance.Sign() < 0 {
+			return fmt.Errorf("negative alloc for %s", addr)
+		}
+	}
+	for addr, stake := range p.Validators {
+		if !common.IsHexAddress(addr) {
+			return fmt.Errorf("invalid validator address %q", addr)
+		}
+		if stake.Cmp(p.MinValidatorStake) < 0 {
+			return fmt.Errorf("validator %s stakes %s ZAR, minimum is %s ZAR", addr, stake, p.MinValidatorStake)
+		}
+	}
+	return nil
+}
+
+// state returns the state the genesis block commits to: the allocated
+// balances and the self stake of the genesis validators.
+func (g *Genesis) state() *State {
+	st := NewState()
+	st.Params = g.Config
+	for addr, balance := range g.Alloc {
+		if !balance.IsZero() {
+			st.credit(addr, balance)
+		}
+	}
+	for addr, stake := range g.Config.Validators {
+		st.Bonds[bondKey(addr, addr)] = stake
+	}
+	return st
+}
+
+// ToBlock returns the genesis block. Its header commits to the allocated
+// state and to Config, so nodes running by other rules, or on another
+// chain ID, don't agree on the genesis hash.
+func (g *Genesis) ToBlock() *Block {
+	var bits uint32
+	if g.Config.Engine == EnginePoW {
+		if bits = g.Bits; bits == 0 {
+			bits = g.Config.PowLimitBits
+		}
+	}
+	b := NewBlock(0, "0", []Transaction{}, bits)
+	b.Timestamp = g.Timestamp
+	b.StateRoot = g.state().Root()
+	b.ConfigHash = g.Config.Hash()
+	b.Hash = b.CalculateHash()
+	b.ChainWork = b.Work()
+	return b
+}
+
+// NewChainFromGenesis starts a chain at the given genesis.
+func NewChainFromGenesis(g *Genesis) (*Chain, error) {
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+	return g.chain(), nil
+}
+
+func (g *Genesis) chain() *Chain {
+	c := &Chain{
+		Blocks:     []*Block{g.ToBlock()},
+		Params:     g.Config,
+		Undo:       make(map[string]StateUndo),
+		SideBlocks: make(map[string]*Block),
+		genesis:    g,
+	}
+	c.setState(g.state())
+	c.indexBlocks()
+	return c
+}
@@ -0,0 +1,128 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Merkle trees over block transactions. Leaves and inner nodes are hashed
+// with different prefixes so a leaf can never be passed off as a node,
+// and an odd node at the end of a level is carried up unchanged rather
+// than paired with itself, so no two transaction lists share a root.
+// Where nodes are carried up depends on the number of leaves, so the
+// transaction root commits to that number too: without it a proof could
+// pass its leaf off at another position in a tree of another size.
+
+const (
+	leafPrefix  = 0x00
+	nodePrefix  = 0x01
+	countPrefix = 0x02
+)
+
+func hashLeaf(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func hashNode(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// countedRoot binds the root of a tree to its number of leaves.
+func countedRoot(root []byte, count int) []byte {
+	h := sha256.New()
+	h.Write([]byte{countPrefix})
+	h.Write(binary.BigEndian.AppendUint64(nil, uint64(count)))
+	h.Write(root)
+	return h.Sum(nil)
+}
+
+// merkleRoot returns the root over already hashed leaves. The root of an
+// empty list is the hash of an empty leaf.
+func merkleRoot(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return hashLeaf(nil)
+	}
+	level := leaves
+	for len(level) > 1 {
+		level = nextLevel(level)
+	}
+	return level[0]
+}
+
+func nextLevel(level [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+		next = append(next, hashNode(level[i], level[i+1]))
+	}
+	return next
+}
+
+// merkleProof returns the siblings on the path from leaf index to the
+// root, bottom up. Levels where the node is carried up have none.
+func merkleProof(leaves [][]byte, index int) [][]byte {
+	var proof [][]byte
+	level := leaves
+	for len(level) > 1 {
+		switch {
+		case index%2 == 1:
+			proof = append(proof, level[index-1])
+		case index+1 < len(level):
+			proof = append(proof, level[index+1])
+		}
+		level = nextLevel(level)
+		index /= 2
+	}
+	return proof
+}
+
+// merkleProofRoot recomputes the root of a count-leaf tree from the leaf
+// at index and its proof. ok is false if the proof has the wrong length.
+func merkleProofRoot(leaf []byte, index, count int, proof [][]byte) (root []byte, ok bool) {
+	hash := leaf
+	for ; count > 1; count = (count + 1) / 2 {
+		switch {
+		case index%2 == 1:
+			if len(proof) == 0 {
+				return nil, false
+			}
+			hash, proof = hashNode(proof[0], hash), proof[1:]
+		case index+1 < count:
+			if len(proof) == 0 {
+				return nil, false
+			}
+			hash, proof = hashNode(hash, proof[0]), proof[1:]
+		}
+		index /= 2
+	}
+	return hash, len(proof) == 0
+}
+
+// leafHash commits to every field of tx exactly as it is stored in the
+// block, unlike Hash, which for wallet transactions covers only the raw
+// envelope.
+func (tx *Transaction) leafHash() []byte {
+	data, _ := json.Marshal(tx)
+	return hashLeaf(data)
+}
+
+// TxRoot returns the hex Merkle root of txs, bound to their number.
+func TxRoot(txs []Transaction) string {
+	leaves := make([][]byte, len(txs))
+	for i := range txs {
+		leaves[i] = txs[i].leafHash()
+	}
+	return hex.EncodeToString(countedRoot(merkleRoot(leaves), len(txs)))
+}
@@ -0,0 +1,144 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// Consensus engines selectable in Params.
+const (
+	EnginePoW = "pow"
+	EnginePoS = "pos"
+)
+
+// Params are the consensus rules every node must agree on to validate the
+// same chain.
+type Params struct {
+	ChainID            int64  `json:"chain_id"`
+	Engine             string `json:"engine,omitempty"`      // EnginePoW or EnginePoS
+	TargetBlockTime    int64  `json:"target_block_time"`     // Seconds between blocks the difficulty aims for
+	RetargetInterval   int64  `json:"retarget_interval"`     // Blocks between difficulty adjustments
+	PowLimitBits       uint32 `json:"pow_limit_bits"`        // Easiest target retargeting may reach, in compact form
+	MaxFutureBlockTime int64  `json:"max_future_block_time"` // How far ahead of local time a block timestamp may be
+
+	// Proof-of-stake
+	BlockPeriod int64             `json:"block_period,omitempty"` // Minimum seconds between blocks
+	Validators  map[string]Amount `json:"validators,omitempty"`   // Self stake bonded per validator at genesis
+
+	// Staking, on either engine
+	EpochLength       int64  `json:"epoch_length,omitempty"`       // Blocks between payouts of the staking pool
+	UnbondingPeriod   int64  `json:"unbonding_period,omitempty"`   // Blocks unstaked funds stay locked
+	MinValidatorStake Amount `json:"min_validator_stake,omitzero"` // Self stake a validator needs to be active
+	SlashBps          int64  `json:"slash_bps,omitempty"`          // Share of stake slashed for double-signing, in basis points
+	JailPeriod        int64  `json:"jail_period,omitempty"`        // Blocks a slashed validator is left out of elections
+
+	// Blocks between checkpoints validators vote on for finality
+	CheckpointInterval int64 `json:"checkpoint_interval,omitempty"`
+
+	// Reward schedule. The fee is taken off every block reward first, and
+	// the treasury gets what the miner and staker shares leave
+	BlockReward     Amount `json:"block_reward"`     // Minted per block
+	HalvingInterval int64  `json:"halving_interval"` // Blocks between halvings of the reward, 0 for none
+	MinerShare      int64  `json:"miner_share"`      // Percent of the reward for the block's coinbase
+	StakerShare     int64  `json:"staker_share"`     // Percent of the reward for the staking pool
+
+	// Developer fee on block rewards and user transfers
+	FeeBps       int64  `json:"fee_bps"`
+	FeeRecipient string `json:"fee_recipient"`
+
+	// Receives the treasury share of block rewards
+	TreasuryAddress string `json:"treasury_address,omitempty"`
+}
+
+func DefaultParams() Params {
+	return Params{
+		ChainID:            MainnetChainID,
+		Engine:             EnginePoW,
+		TargetBlockTime:    15,
+		RetargetInterval:   10,
+		PowLimitBits:       DifficultyToBits(1),
+		MaxFutureBlockTime: 120,
+		BlockPeriod:        5,
+		EpochLength:        100,
+		UnbondingPeriod:    1000,
+		MinValidatorStake:  ZAR(100),
+		SlashBps:           500,
+		JailPeriod:         1000,
+		CheckpointInterval: 10,
+		BlockReward:        ZAR(10),
+		MinerShare:         60,
+		StakerShare:        30,
+		FeeBps:             1, // 0.01%
+		FeeRecipient:       "0xA048F7cfFb548B05eA90ab94962ED0e9A7fC865b",
+		TreasuryAddress:    "0xTreasuryFundAddress1234567890abcdef",
+	}
+}
+
+// Hash returns the hash of the canonical encoding of p, which the genesis
+// block commits to.
+func (p Params) Hash() string {
+	data, _ := json.Marshal(p)
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+// RewardAt returns the block reward at height, halved once every
+// HalvingInterval blocks.
+func (p Params) RewardAt(height int64) Amount {
+	if p.HalvingInterval <= 0 {
+		return p.BlockReward
+	}
+	halvings := height / p.HalvingInterval
+	if halvings >= 256 {
+		return Amount{}
+	}
+	return NewAmount(new(big.Int).Rsh(p.BlockReward.Wei(), uint(halvings)))
+}
+
+// withDefaults fills in parameters missing from older chain data. The
+// reward and fee settings can legitimately be zero, so chain data and
+// genesis files are decoded over DefaultParams instead.
+func (p Params) withDefaults() Params {
+	d := DefaultParams()
+	if p.Engine == "" {
+		p.Engine = d.Engine
+	}
+	if p.TargetBlockTime <= 0 {
+		p.TargetBlockTime = d.TargetBlockTime
+	}
+	if p.RetargetInterval <= 1 {
+		p.RetargetInterval = d.RetargetInterval
+	}
+	if p.PowLimitBits == 0 {
+		p.PowLimitBits = d.PowLimitBits
+	}
+	if p.MaxFutureBlockTime <= 0 {
+		p.MaxFutureBlockTime = d.MaxFutureBlockTime
+	}
+	if p.BlockPeriod <= 0 {
+		p.BlockPeriod = d.BlockPeriod
+	}
+	if p.EpochLength <= 0 {
+		p.EpochLength = d.EpochLength
+	}
+	if p.UnbondingPeriod <= 0 {
+		p.UnbondingPeriod = d.UnbondingPeriod
+	}
+	if p.MinValidatorStake.IsZero() {
+		p.MinValidatorStake = d.MinValidatorStake
+	}
+	if p.SlashBps <= 0 {
+		p.SlashBps = d.SlashBps
+	}
+	if p.JailPeriod <= 0 {
+		p.JailPeriod = d.JailPeriod
+	}
+	if p.CheckpointInterval <= 0 {
+		p.CheckpointInterval = d.CheckpointInterval
+	}
+	if p.TreasuryAddress == "" {
+		p.TreasuryAddress = d.TreasuryAddress
+	}
+	return p
+}
@@ -0,0 +1,129 @@
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Proof-of-work targets are 256-bit numbers a block hash must not exceed.
+// They are stored in blocks in Bitcoin's compact "bits" form: one exponent
+// byte (length in bytes) and a three-byte mantissa.
+
+var oneLsh256 = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// CompactToBig expands compact bits into the full target.
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	exponent := uint(compact >> 24)
+
+	var n *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		n = big.NewInt(int64(mantissa))
+	} else {
+		n = big.NewInt(int64(mantissa))
+		n.Lsh(n, 8*(exponent-3))
+	}
+	if compact&0x00800000 != 0 {
+		n.Neg(n)
+	}
+	return n
+}
+
+// BigToCompact encodes a target as compact bits, truncating it to the
+// three most significant bytes.
+func BigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(n.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(new(big.Int).Abs(n).Uint64())
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		t := new(big.Int).Rsh(new(big.Int).Abs(n), 8*(exponent-3))
+		mantissa = uint32(t.Uint64())
+	}
+
+	// The top mantissa bit is the sign, so shift into the exponent instead
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if n.Sign() < 0 {
+		compact |= 0x00800000
+	}
+	return compact
+}
+
+// Range of legacy difficulties. A hash has 64 hex digits, and at least one
+// of them must be free.
+const (
+	MinDifficulty = 1
+	MaxDifficulty = 63
+)
+
+// DifficultyToBits converts a legacy difficulty (number of leading zero
+// hex digits) to the equivalent target: a hash has d leading zero digits
+// exactly when it is below 2^(256-4d). Difficulties out of range are
+// clamped to it; blocks carrying one are rejected by checkDifficulty.
+func DifficultyToBits(difficulty int) uint32 {
+	difficulty = min(max(difficulty, MinDifficulty), MaxDifficulty)
+	return BigToCompact(new(big.Int).Lsh(big.NewInt(1), uint(256-4*difficulty)))
+}
+
+// checkDifficulty checks the legacy difficulty of blocks from before
+// compact targets.
+func checkDifficulty(b *Block) error {
+	if b.Version < BlockVersionCompact && (b.Difficulty < MinDifficulty || b.Difficulty > MaxDifficulty) {
+		return fmt.Errorf("block %d has difficulty %d, must be %d to %d", b.Index, b.Difficulty, MinDifficulty, MaxDifficulty)
+	}
+	return nil
+}
+
+// CalcWork returns the expected number of hashes needed to meet bits,
+// 2^256 / (target + 1). Chains are compared by the sum of their work.
+func CalcWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return new(big.Int)
+	}
+	return new(big.Int).Div(oneLsh256, target.Add(target, big.NewInt(1)))
+}
+
+// HashToBig interprets a hex block hash as a 256-bit number.
+func HashToBig(hash string) *big.Int {
+	n, ok := new(big.Int).SetString(hash, 16)
+	if !ok {
+		return new(big.Int).Set(oneLsh256)
+	}
+	return n
+}
+
+// TargetBits returns the compact target the block hash must meet. Blocks
+// from before compact targets are converted from their hex-zero
+// difficulty. Proof-of-stake blocks have no target.
+func (b *Block) TargetBits() uint32 {
+	if b.Version < BlockVersionCompact {
+		return DifficultyToBits(b.Difficulty)
+	}
+	return b.Bits
+}
+
+func (b *Block) Target() *big.Int {
+	return CompactToBig(b.TargetBits())
+}
+
+// Work returns the weight the block adds to its branch for fork choice:
+// the expected number of hashes that went into a proof-of-work block, or
+// 1 for a proof-of-stake block, making the longest branch the heaviest.
+func (b *Block) Work() *big.Int {
+	if b.Version >= BlockVersionCompact && b.Bits == 0 {
+		return big.NewInt(1)
+	}
+	return CalcWork(b.TargetBits())
+}
@@ -0,0 +1,104 @@
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestDifficultyToBits(t *testing.T) {
+	for d := MinDifficulty; d <= MaxDifficulty; d++ {
+		want := new(big.Int).Lsh(big.NewInt(1), uint(256-4*d))
+		if got := CompactToBig(DifficultyToBits(d)); got.Cmp(want) != 0 {
+			t.Errorf("difficulty %d: target %x, want %x", d, got, want)
+		}
+	}
+	// Out of range difficulties are clamped rather than wrapped around
+	for _, tt := range []struct{ in, clamped int }{{-1, MinDifficulty}, {0, MinDifficulty}, {64, MaxDifficulty}, {1000, MaxDifficulty}} {
+		if got, want := DifficultyToBits(tt.in), DifficultyToBits(tt.clamped); got != want {
+			t.Errorf("DifficultyToBits(%d) = %08x, want %08x", tt.in, got, want)
+		}
+	}
+}
+
+func TestCheckDifficulty(t *testing.T) {
+	tests := []struct {
+		version, difficulty int
+		ok                  bool
+	}{
+		{BlockVersionLegacy, MinDifficulty, true},
+		{BlockVersionWei, MaxDifficulty, true},
+		{BlockVersionLegacy, 0, false},
+		{BlockVersionWei, -3, false},
+		{BlockVersionLegacy, 64, false},
+		{BlockVersionCompact, 0, true}, // Compact blocks carry Bits instead
+	}
+	for _, tt := range tests {
+		b := &Block{Header: Header{Version: tt.version, Difficulty: tt.difficulty}}
+		if err := checkDifficulty(b); (err == nil) != tt.ok {
+			t.Errorf("version %d, difficulty %d: checkDifficulty = %v", tt.version, tt.difficulty, err)
+		}
+	}
+}
+
+func TestCompactRoundTrip(t *testing.T) {
+	tests := []struct {
+		bits   uint32
+		target string // Hex, "-" prefixed when negative
+	}{
+		{0x00000000, "0"},
+		{0x01003456, "0"},
+		{0x01120000, "12"},
+		{0x02008000, "80"},
+		{0x05009234, "92340000"},
+		{0x04923456, "-12345600"},
+		{0x1d00ffff, "ffff0000000000000000000000000000000000000000000000000000"},
+		{0x20123456, "1234560000000000000000000000000000000000000000000000000000000000"},
+	}
+	for _, tt := range tests {
+		want, _ := new(big.Int).SetString(tt.target, 16)
+		got := CompactToBig(tt.bits)
+		if got.Cmp(want) != 0 {
+			t.Errorf("CompactToBig(%08x) = %x, want %s", tt.bits, got, tt.target)
+		}
+		if got.Sign() == 0 {
+			continue
+		}
+		if back := BigToCompact(got); back != tt.bits {
+			t.Errorf("BigToCompact(%x) = %08x, want %08x", got, back, tt.bits)
+		}
+	}
+
+	// Bytes below the exponent are dropped when decoding, and targets are
+	// truncated to three significant bytes when encoding
+	if got := CompactToBig(0x01123456); got.Int64() != 0x12 {
+		t.Errorf("CompactToBig(01123456) = %x, want 12", got)
+	}
+	n, _ := new(big.Int).SetString("123456789abc", 16)
+	if got := BigToCompact(n); got != 0x06123456 {
+		t.Errorf("BigToCompact(%x) = %08x, want 06123456", n, got)
+	}
+	// A mantissa with its top bit set moves up a byte so it isn't negative
+	if got := BigToCompact(big.NewInt(0x80)); got != 0x02008000 {
+		t.Errorf("BigToCompact(0x80) = %08x, want 02008000", got)
+	}
+}
+
+func TestWork(t *testing.T) {
+	tests := []struct {
+		name string
+		b    Header
+		want string
+	}{
+		{"bitcoin genesis target", Header{Version: BlockVersionCompact, Bits: 0x1d00ffff}, "4295032833"},
+		{"half the hash space", Header{Version: BlockVersionCompact, Bits: BigToCompact(new(big.Int).Lsh(big.NewInt(1), 255))}, "1"},
+		{"legacy difficulty 2", Header{Version: BlockVersionLegacy, Difficulty: 2}, "255"},
+		{"proof of stake", Header{Version: CurrentBlockVersion}, "1"},
+		{"negative target", Header{Version: BlockVersionCompact, Bits: 0x04923456}, "0"},
+	}
+	for _, tt := range tests {
+		b := &Block{Header: tt.b}
+		if got := b.Work().String(); got != tt.want {
+			t.Errorf("%s: work %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
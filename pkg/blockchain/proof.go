@@ -0,0 +1,185 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrTxNotFound   = errors.New("transaction not found")
+	ErrBlockUnknown = errors.New("block not found")
+	ErrNoStateRoot  = errors.New("block predates state roots")
+	ErrInvalidProof = errors.New("invalid Merkle proof")
+)
+
+// TxProof shows that a transaction is included in a block. It is checked
+// against the TxRoot of a header the verifier already trusts.
+type TxProof struct {
+	BlockHash   string      `json:"block_hash"`
+	BlockIndex  int64       `json:"block_index"`
+	TxRoot      string      `json:"tx_root"`
+	Index       int         `json:"index"` // Position of the transaction in the block
+	Count       int         `json:"count"` // Number of transactions in the block
+	Transaction Transaction `json:"transaction"`
+	Siblings    []string    `json:"siblings"` // Bottom up
+}
+
+// AccountProof shows an account's balance and nonce after a block, or
+// that the account is empty. It is checked against the StateRoot of a
+// header the verifier already trusts.
+type AccountProof struct {
+	BlockHash  string   `json:"block_hash"`
+	BlockIndex int64    `json:"block_index"`
+	StateRoot  string   `json:"state_root"`
+	Address    string   `json:"address"`
+	Balance    Amount   `json:"balance"`
+	Nonce      uint64   `json:"nonce"`
+	Siblings   []string `json:"siblings"` // All 256 levels, root first
+}
+
+// VerifyTxProof checks that p proves its transaction against txRoot.
+// txRoot commits to the number of transactions, which fixes the siblings
+// the leaf at p.Index must have, so a proof only verifies with its
+// transaction's real position and block size.
+func VerifyTxProof(txRoot string, p *TxProof) error {
+	if p.Count < 1 || p.Count > MaxBlockTxs {
+		return fmt.Errorf("%w: block of %d transactions", ErrInvalidProof, p.Count)
+	}
+	if p.Index < 0 || p.Index >= p.Count {
+		return fmt.Errorf("%w: index %d out of range", ErrInvalidProof, p.Index)
+	}
+	siblings, err := decodeHashes(p.Siblings)
+	if err != nil {
+		return err
+	}
+	root, ok := merkleProofRoot(p.Transaction.leafHash(), p.Index, p.Count, siblings)
+	if !ok {
+		return fmt.Errorf("%w: got %d siblings for transaction %d of %d", ErrInvalidProof, len(siblings), p.Index, p.Count)
+	}
+	if hex.EncodeToString(countedRoot(root, p.Count)) != txRoot {
+		return fmt.Errorf("%w: root mismatch", ErrInvalidProof)
+	}
+	return nil
+}
+
+// VerifyAccountProof checks that p proves its balance and nonce against
+// stateRoot.
+func VerifyAccountProof(stateRoot string, p *AccountProof) error {
+	if len(p.Siblings) != smtDepth {
+		return fmt.Errorf("%w: got %d siblings, want %d", ErrInvalidProof, len(p.Siblings), smtDepth)
+	}
+	siblings, err := decodeHashes(p.Siblings)
+	if err != nil {
+		return err
+	}
+	path := accountPath(p.Address)
+	leaf := emptyHashes[0]
+	if !p.Balance.IsZero() || p.Nonce != 0 {
+		leaf = accountLeafHash(path, p.Balance, p.Nonce)
+	}
+	if hex.EncodeToString(smtProofRoot(leaf, path, siblings)) != stateRoot {
+		return fmt.Errorf("%w: root mismatch", ErrInvalidProof)
+	}
+	return nil
+}
+
+// TxProof finds the transaction with the given hash and proves its
+// inclusion.
+func (c *Chain) TxProof(txHash string) (*TxProof, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := len(c.Blocks) - 1; i >= 0; i-- {
+		b := c.Blocks[i]
+		for j := range b.Transactions {
+			if !strings.EqualFold(b.Transactions[j].Hash(), txHash) {
+				continue
+			}
+			if b.TxRoot == "" {
+				return nil, fmt.Errorf("block %d predates transaction roots", b.Index)
+			}
+			leaves := make([][]byte, len(b.Transactions))
+			for k := range b.Transactions {
+				leaves[k] = b.Transactions[k].leafHash()
+			}
+			return &TxProof{
+				BlockHash:   b.Hash,
+				BlockIndex:  b.Index,
+				TxRoot:      b.TxRoot,
+				Index:       j,
+				Count:       len(b.Transactions),
+				Transaction: b.Transactions[j],
+				Siblings:    encodeHashes(merkleProof(leaves, j)),
+			}, nil
+		}
+	}
+	return nil, ErrTxNotFound
+}
+
+// AccountProof proves the state of addr after block index.
+func (c *Chain) AccountProof(addr string, index int64) (*AccountProof, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st, err := c.stateAt(index)
+	if err != nil {
+		return nil, err
+	}
+	b := c.Blocks[index]
+	if b.StateRoot == "" {
+		return nil, fmt.Errorf("block %d: %w", index, ErrNoStateRoot)
+	}
+	leaves := st.leaves()
+	if root := hex.EncodeToString(smtRoot(leaves, 0)); root != b.StateRoot {
+		return nil, fmt.Errorf("state after block %d does not match its root %s (%s)", index, b.StateRoot, root)
+	}
+	return &AccountProof{
+		BlockHash:  b.Hash,
+		BlockIndex: b.Index,
+		StateRoot:  b.StateRoot,
+		Address:    addr,
+		Balance:    st.Balance(addr),
+		Nonce:      st.Nonce(addr),
+		Siblings:   encodeHashes(smtProof(leaves, accountPath(addr))),
+	}, nil
+}
+
+// stateAt reconstructs the state after block index by reverting the
+// blocks above it.
+func (c *Chain) stateAt(index int64) (*State, error) {
+	if index < 0 || index >= int64(len(c.Blocks)) {
+		return nil, fmt.Errorf("%w: %d", ErrBlockUnknown, index)
+	}
+	st := c.state().Copy()
+	for i := int64(len(c.Blocks)) - 1; i > index; i-- {
+		undo, ok := c.Undo[c.Blocks[i].Hash]
+		if !ok {
+			return nil, fmt.Errorf("no undo data for block %d", i)
+		}
+		st.Revert(undo)
+	}
+	st.Height = index + 1
+	return st, nil
+}
+
+func encodeHashes(hashes [][]byte) []string {
+	out := make([]string, len(hashes))
+	for i, h := range hashes {
+		out[i] = hex.EncodeToString(h)
+	}
+	return out
+}
+
+func decodeHashes(hashes []string) ([][]byte, error) {
+	out := make([][]byte, len(hashes))
+	for i, h := range hashes {
+		b, err := hex.DecodeString(h)
+		if err != nil || len(b) != 32 {
+			return nil, fmt.Errorf("%w: bad hash %q", ErrInvalidProof, h)
+		}
+		out[i] = b
+	}
+	return out, nil
+}
@@ -0,0 +1,47 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestVerifyTxProof(t *testing.T) {
+	txs := make([]Transaction, 5)
+	leaves := make([][]byte, len(txs))
+	for i := range txs {
+		txs[i] = Transaction{ID: fmt.Sprint(i), Sender: "SYSTEM", Receiver: "0x00000000000000000000000000000000000000aa", Amount: ZAR(int64(i + 1))}
+		leaves[i] = txs[i].leafHash()
+	}
+	root := TxRoot(txs)
+	proof := func(index int) TxProof {
+		return TxProof{Index: index, Count: len(txs), Transaction: txs[index], Siblings: encodeHashes(merkleProof(leaves, index))}
+	}
+	for i := range txs {
+		p := proof(i)
+		if err := VerifyTxProof(root, &p); err != nil {
+			t.Errorf("transaction %d: %v", i, err)
+		}
+	}
+
+	// The last transaction is carried up to the top level, where its only
+	// sibling is the root of the first four. In a two-leaf tree the same
+	// sibling would put it at index 1.
+	tests := []struct {
+		name  string
+		forge func(p *TxProof)
+	}{
+		{"smaller block", func(p *TxProof) { p.Index, p.Count = 1, 2 }},
+		{"other index", func(p *TxProof) { p.Index = 3 }},
+		{"extra sibling", func(p *TxProof) { p.Siblings = append(p.Siblings, p.Siblings[0]) }},
+		{"no transactions", func(p *TxProof) { p.Index, p.Count = 0, 0 }},
+		{"other transaction", func(p *TxProof) { p.Transaction = txs[0] }},
+	}
+	for _, tt := range tests {
+		p := proof(4)
+		tt.forge(&p)
+		if err := VerifyTxProof(root, &p); !errors.Is(err, ErrInvalidProof) {
+			t.Errorf("%s: VerifyTxProof = %v, want ErrInvalidProof", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"zar-blockchain/pkg/wallet"
+)
+
+// TxEvidence reports a validator that signed two different blocks, or
+// voted for two different checkpoints, at the same height. Anyone can
+// send one; the offender's stake is slashed, the sender gets a share of it
+// and the validator is jailed.
+const TxEvidence = "evidence"
+
+// reporterShare is the fraction of a slash paid to whoever reported it.
+// The rest goes to the staking pool, so honest stakers get it at the end
+// of the epoch.
+const reporterShare = 10 // Percent
+
+var (
+	ErrInvalidEvidence   = errors.New("invalid double-sign evidence")
+	ErrDuplicateEvidence = errors.New("offence already slashed")
+)
+
+// SignedHeader is a block header with the validator's signature over its
+// hash and the chain ID, see Block.SigningPayload.
+type SignedHeader struct {
+	Header
+	Signature string `json:"signature"`
+}
+
+// DoubleSignEvidence holds two different blocks signed by the same
+// validator at the same height, or two of its checkpoint votes for
+// different blocks at the same height. A and B are unused in the latter.
+type DoubleSignEvidence struct {
+	A     SignedHeader `json:"a"`
+	B     SignedHeader `json:"b"`
+	Votes []Vote       `json:"votes,omitempty"`
+}
+
+// Height returns the height of the offence.
+func (ev *DoubleSignEvidence) Height() int64 {
+	if len(ev.Votes) > 0 {
+		return ev.Votes[0].Height
+	}
+	return ev.A.Index
+}
+
+// offender returns the address of the validator the evidence is against.
+func (ev *DoubleSignEvidence) offender() string {
+	if len(ev.Votes) > 0 {
+		return ev.Votes[0].Validator
+	}
+	return ev.A.Validator
+}
+
+func signedHeader(b *Block) SignedHeader {
+	return SignedHeader{Header: b.Header, Signature: b.Signature}
+}
+
+func (h SignedHeader) verify(chainID int64) error {
+	if h.Version < BlockVersionHeader {
+		return fmt.Errorf("%w: header version %d", ErrInvalidEvidence, h.Version)
+	}
+	b := Block{Header: h.Header, Signature: h.Signature}
+	b.Hash = h.CalculateHash()
+	if err := b.VerifySignature(chainID); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEvidence, err)
+	}
+	return nil
+}
+
+// Verify checks that the evidence proves a double sign on the chain
+// chainID and returns the offending validator. Headers and votes signed
+// for another chain are no evidence against this one.
+func (ev *DoubleSignEvidence) Verify(chainID int64) (string, error) {
+	if len(ev.Votes) > 0 {
+		return ev.verifyVotes(chainID)
+	}
+	if ev.A.Index != ev.B.Index || !wallet.SameAddress(ev.A.Validator, ev.B.Validator) {
+		return "", fmt.Errorf("%w: headers are from different heights or validators", ErrInvalidEvidence)
+	}
+	if ev.A.CalculateHash() == ev.B.CalculateHash() {
+		return "", fmt.Errorf("%w: headers are the same block", ErrInvalidEvidence)
+	}
+	if err := ev.A.verify(chainID); err != nil {
+		return "", err
+	}
+	if err := ev.B.verify(chainID); err != nil {
+		return "", err
+	}
+	return accountKey(ev.A.Validator), nil
+}
+
+func (ev *DoubleSignEvidence) verifyVotes(chainID int64) (string, error) {
+	if len(ev.Votes) != 2 {
+		return "", fmt.Errorf("%w: got %d votes, want 2", ErrInvalidEvidence, len(ev.Votes))
+	}
+	a, b := ev.Votes[0], ev.Votes[1]
+	if a.Height != b.Height || !wallet.SameAddress(a.Validator, b.Validator) {
+		return "", fmt.Errorf("%w: votes are from different heights or validators", ErrInvalidEvidence)
+	}
+	if a.Hash == b.Hash {
+		return "", fmt.Errorf("%w: votes are for the same block", ErrInvalidEvidence)
+	}
+	for _, v := range ev.Votes {
+		if err := v.Verify(chainID); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidEvidence, err)
+		}
+	}
+	return accountKey(a.Validator), nil
+}
+
+func slashKey(height int64, validator string) string {
+	return strconv.FormatInt(height, 10) + "/" + accountKey(validator)
+}
+
+// jailed reports whether validator is serving a jail term at s.Height.
+func (s *State) jailed(validator string) bool {
+	for key, until := range s.Slashes {
+		if _, v, _ := strings.Cut(key, "/"); v == validator && until > s.Height {
+			return true
+		}
+	}
+	return false
+}
+
+// checkEvidence checks that an evidence transaction proves an offence
+// that can still be punished.
+func (s *State) checkEvidence(tx Transaction) error {
+	if tx.Evidence == nil {
+		return fmt.Errorf("%w: missing", ErrInvalidEvidence)
+	}
+	if !tx.Amount.IsZero() {
+		return errors.New("evidence transactions carry no amount")
+	}
+	validator, err := tx.Evidence.Verify(s.Params.ChainID)
+	if err != nil {
+		return err
+	}
+	if accountKey(tx.Receiver) != validator {
+		return fmt.Errorf("%w: receiver is not the offender", ErrInvalidEvidence)
+	}
+	height := tx.Evidence.Height()
+	// Older offences may have had their stake unbonded already
+	if height >= s.Height || height < s.Height-s.Params.UnbondingPeriod {
+		return fmt.Errorf("%w: offence at height %d is out of range", ErrInvalidEvidence, height)
+	}
+	if _, ok := s.Slashes[slashKey(height, validator)]; ok {
+		return ErrDuplicateEvidence
+	}
+	return nil
+}
+
+// slash punishes the validator proven by a checked evidence transaction.
+// SlashBps of every bond to the validator is taken, as well as of stake
+// that started unbonding from it at or after the offence, and the
+// validator is jailed for JailPeriod blocks.
+func (s *State) slash(tx Transaction) {
+	validator := accountKey(tx.Receiver)
+	height := tx.Evidence.Height()
+
+	var slashed Amount
+	cut := func(m map[string]Amount, key string, amount Amount) {
+		c := amount.Bps(s.Params.SlashBps)
+		if left := amount.Sub(c); left.IsZero() {
+			delete(m, key)
+		} else {
+			m[key] = left
+		}
+		slashed = slashed.Add(c)
+	}
+	for key, amount := range s.Bonds {
+		if v, _ := parseBondKey(key); v == validator {
+			cut(s.Bonds, key, amount)
+		}
+	}
+	for key, amount := range s.Unbonding {
+		if start, v, _ := parseUnbondKey(key); v == validator && start >= height {
+			cut(s.Unbonding, key, amount)
+		}
+	}
+
+	reward := slashed.MulFrac(reporterShare, 100)
+	s.credit(tx.Sender, reward)
+	s.credit(StakingPoolAddress, slashed.Sub(reward))
+	s.Slashes[slashKey(height, validator)] = s.Height + s.Params.JailPeriod
+}
+
+// checkDoubleSign looks up the block known to be signed by block's
+// validator at the same height and publishes the pair as evidence if it
+// is another one. AddBlock calls it once block's header has verified.
+func (c *Chain) checkDoubleSign(block *Block) {
+	if block.Validator == "" {
+		return
+	}
+	other, ok := c.signers[voteKey(block.Index, block.Validator)]
+	if !ok || other.Hash == block.Hash {
+		return
+	}
+	ev := &DoubleSignEvidence{A: signedHeader(other), B: signedHeader(block)}
+	if _, err := ev.Verify(c.Params.ChainID); err != nil {
+		return
+	}
+	fmt.Printf("[STAKING] Validator %s signed two blocks at height %d\n", block.Validator, block.Index)
+	c.notifyEvidence(ev)
+}
+
+// indexSigner records b as the block its validator signed at its height,
+// unless one was known already.
+func (c *Chain) indexSigner(b *Block) {
+	if b.Validator == "" {
+		return
+	}
+	key := voteKey(b.Index, b.Validator)
+	if _, ok := c.signers[key]; !ok {
+		c.signers[key] = b
+	}
+}
+
+// unindexSigner forgets b, which left the block tree.
+func (c *Chain) unindexSigner(b *Block) {
+	if b == nil || b.Validator == "" {
+		return
+	}
+	key := voteKey(b.Index, b.Validator)
+	if c.signers[key] == b {
+		delete(c.signers, key)
+	}
+}
+
+// ReportEvidence submits ev to the mempool in a transaction signed by w,
+// which receives the reporter's share of the slash.
+func (c *Chain) ReportEvidence(ev *DoubleSignEvidence, w *wallet.Wallet) error {
+	tx := Transaction{
+		ID:        fmt.Sprintf("evidence-%d-%s", ev.Height(), accountKey(ev.offender())),
+		Type:      TxEvidence,
+		Sender:    w.Address,
+		Receiver:  ev.offender(),
+		Nonce:     c.PendingNonce(w.Address),
+		Timestamp: time.Now().Unix(),
+		Evidence:  ev,
+	}
+	if err := tx.Sign(w, c.Params.ChainID); err != nil {
+		return err
+	}
+	return c.AddPendingTransaction(tx)
+}
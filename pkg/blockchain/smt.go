@@ -0,0 +1,174 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// Account state is committed to by a sparse Merkle tree of depth 256.
+// Every account sits at the leaf indexed by the sha256 of its lowercase
+// address, so the root doesn't depend on insertion order and an absent
+// account can be proven by showing the empty leaf at its path. Bonds and
+// unbonding entries live in the same tree under paths of their own, kept
+// apart from account paths by a domain byte hashed in front of the key.
+
+// Domain bytes of tree paths.
+const (
+	accountDomain byte = 0x00
+	stakeDomain   byte = 0x01
+)
+
+const smtDepth = 256
+
+// emptyHashes[h] is the root of an empty subtree of height h.
+var emptyHashes [smtDepth + 1][]byte
+
+func init() {
+	emptyHashes[0] = make([]byte, sha256.Size)
+	for h := 1; h <= smtDepth; h++ {
+		emptyHashes[h] = hashNode(emptyHashes[h-1], emptyHashes[h-1])
+	}
+}
+
+type smtLeaf struct {
+	path [32]byte
+	hash []byte
+}
+
+func accountPath(addr string) [32]byte {
+	return sha256.Sum256(append([]byte{accountDomain}, accountKey(addr)...))
+}
+
+// accountLeafHash commits to an account's path, balance and nonce.
+func accountLeafHash(path [32]byte, balance Amount, nonce uint64) []byte {
+	data := make([]byte, 0, 32+32+8)
+	data = append(data, path[:]...)
+	data = append(data, balance.Wei().FillBytes(make([]byte, 32))...)
+	data = binary.BigEndian.AppendUint64(data, nonce)
+	return hashLeaf(data)
+}
+
+// stakePath is the tree path of a staking entry. Its domain byte keeps it
+// apart from every account path, whatever the key, and the prefix apart
+// from entries of other kinds.
+func stakePath(prefix, key string) [32]byte {
+	return sha256.Sum256(append([]byte{stakeDomain}, prefix+":"+key...))
+}
+
+func stakeLeafHash(path [32]byte, amount Amount) []byte {
+	data := make([]byte, 0, 32+32)
+	data = append(data, path[:]...)
+	data = append(data, amount.Wei().FillBytes(make([]byte, 32))...)
+	return hashLeaf(data)
+}
+
+// pathBit returns bit i of path, counting from the most significant.
+func pathBit(path [32]byte, i int) byte {
+	return (path[i/8] >> (7 - uint(i%8))) & 1
+}
+
+// leaves returns the tree leaves of every non-empty account sorted by
+// path. Accounts with a zero balance and nonce are left out, so they
+// don't change the root whether or not they have a map entry. Two entries
+// sharing a path would make the root depend on map order, so leaves
+// panics rather than let nodes disagree on it.
+func (s *State) leaves() []smtLeaf {
+	seen := make(map[string]bool, len(s.Balances))
+	var leaves []smtLeaf
+	add := func(addr string) {
+		if seen[addr] {
+			return
+		}
+		seen[addr] = true
+		bal, nonce := s.Balances[addr], s.Nonces[addr]
+		if bal.IsZero() && nonce == 0 {
+			return
+		}
+		path := accountPath(addr)
+		leaves = append(leaves, smtLeaf{path: path, hash: accountLeafHash(path, bal, nonce)})
+	}
+	for addr := range s.Balances {
+		add(addr)
+	}
+	for addr := range s.Nonces {
+		add(addr)
+	}
+	addStakes := func(prefix string, m map[string]Amount) {
+		for key, amount := range m {
+			if amount.IsZero() {
+				continue
+			}
+			path := stakePath(prefix, key)
+			leaves = append(leaves, smtLeaf{path: path, hash: stakeLeafHash(path, amount)})
+		}
+	}
+	addStakes("bond", s.Bonds)
+	addStakes("unbond", s.Unbonding)
+	for key, until := range s.Slashes {
+		path := stakePath("slash", key)
+		data := binary.BigEndian.AppendUint64(append([]byte(nil), path[:]...), uint64(until))
+		leaves = append(leaves, smtLeaf{path: path, hash: hashLeaf(data)})
+	}
+	sort.Slice(leaves, func(i, j int) bool {
+		return bytes.Compare(leaves[i].path[:], leaves[j].path[:]) < 0
+	})
+	for i := 1; i < len(leaves); i++ {
+		if leaves[i].path == leaves[i-1].path {
+			panic(fmt.Sprintf("state tree: two entries at path %x", leaves[i].path))
+		}
+	}
+	return leaves
+}
+
+// Root returns the hex sparse Merkle root of the state.
+func (s *State) Root() string {
+	return hex.EncodeToString(smtRoot(s.leaves(), 0))
+}
+
+// smtRoot returns the root of the subtree at depth holding leaves, which
+// must be sorted and share their first depth path bits.
+func smtRoot(leaves []smtLeaf, depth int) []byte {
+	if len(leaves) == 0 {
+		return emptyHashes[smtDepth-depth]
+	}
+	if depth == smtDepth {
+		return leaves[0].hash
+	}
+	split := sort.Search(len(leaves), func(i int) bool { return pathBit(leaves[i].path, depth) == 1 })
+	return hashNode(smtRoot(leaves[:split], depth+1), smtRoot(leaves[split:], depth+1))
+}
+
+// smtProof returns the 256 siblings on the path to the leaf at path,
+// from the root down.
+func smtProof(leaves []smtLeaf, path [32]byte) [][]byte {
+	siblings := make([][]byte, smtDepth)
+	for depth := 0; depth < smtDepth; depth++ {
+		split := sort.Search(len(leaves), func(i int) bool { return pathBit(leaves[i].path, depth) == 1 })
+		if pathBit(path, depth) == 0 {
+			siblings[depth] = smtRoot(leaves[split:], depth+1)
+			leaves = leaves[:split]
+		} else {
+			siblings[depth] = smtRoot(leaves[:split], depth+1)
+			leaves = leaves[split:]
+		}
+	}
+	return siblings
+}
+
+// smtProofRoot folds a leaf hash and its root-first siblings back up to
+// the root.
+func smtProofRoot(leaf []byte, path [32]byte, siblings [][]byte) []byte {
+	hash := leaf
+	for depth := smtDepth - 1; depth >= 0; depth-- {
+		if pathBit(path, depth) == 0 {
+			hash = hashNode(hash, siblings[depth])
+		} else {
+			hash = hashNode(siblings[depth], hash)
+		}
+	}
+	return hash
+}
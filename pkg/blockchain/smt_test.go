@@ -0,0 +1,37 @@
+package blockchain
+
+import "testing"
+
+// TestStatePathDomains checks that no account can sit at the path of a
+// staking entry, whatever its name.
+func TestStatePathDomains(t *testing.T) {
+	key := bondKey("0x00000000000000000000000000000000000000aa", "0x00000000000000000000000000000000000000bb")
+	for _, prefix := range []string{"bond", "unbond", "slash"} {
+		if accountPath(prefix+":"+key) == stakePath(prefix, key) {
+			t.Errorf("account %s:%s shares the path of a %s entry", prefix, key, prefix)
+		}
+	}
+
+	s := &State{
+		Balances: map[string]Amount{"bond:" + key: ZAR(1)},
+		Nonces:   map[string]uint64{},
+		Bonds:    map[string]Amount{key: ZAR(2)},
+	}
+	if n := len(s.leaves()); n != 2 {
+		t.Errorf("state has %d leaves, want 2", n)
+	}
+}
+
+func TestStateDuplicatePath(t *testing.T) {
+	// Entries that differ only in case can't be told apart by path
+	s := &State{
+		Balances: map[string]Amount{"0x00000000000000000000000000000000000000AA": ZAR(1)},
+		Nonces:   map[string]uint64{"0x00000000000000000000000000000000000000aa": 1},
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("leaves accepted two entries at one path")
+		}
+	}()
+	s.leaves()
+}
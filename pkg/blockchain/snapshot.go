@@ -0,0 +1,404 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Snapshot is the state after a main chain block: every account and
+// stake, which is all it takes to carry on from that block without the
+// blocks before it. It is checked against the block's state root.
+type Snapshot struct {
+	Height    int64              `json:"height"`
+	Hash      string             `json:"hash"`
+	Accounts  map[string]Account `json:"accounts"`
+	Bonds     map[string]Amount  `json:"bonds,omitempty"`
+	Unbonding map[string]Amount  `json:"unbonding,omitempty"`
+	Slashes   map[string]int64   `json:"slashes,omitempty"`
+}
+
+// SnapshotConfig controls the snapshots a node takes and how much block
+// history it keeps.
+type SnapshotConfig struct {
+	// Interval takes a snapshot of the state after every block whose
+	// height is a multiple of it; 0 takes none.
+	Interval int64
+	// KeepBodies is how many blocks below the tip keep their transactions
+	// and undo data. Older ones are pruned back to the newest snapshot at
+	// least that deep, leaving their headers; 0 keeps every block. Reorgs
+	// can't reach below a pruned block.
+	KeepBodies int64
+}
+
+type snapshotRef struct {
+	Height int64
+	Hash   string
+}
+
+var ErrNoSnapshot = errors.New("no snapshot")
+
+// SetSnapshots sets the snapshot and pruning policy. It applies from the
+// next block on.
+func (c *Chain) SetSnapshots(cfg SnapshotConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.snapshots = cfg
+}
+
+func newSnapshot(b *Block, st *State) *Snapshot {
+	s := &Snapshot{
+		Height:    b.Index,
+		Hash:      b.Hash,
+		Accounts:  make(map[string]Account),
+		Bonds:     st.Bonds,
+		Unbonding: st.Unbonding,
+		Slashes:   st.Slashes,
+	}
+	for addr, bal := range st.Balances {
+		if !bal.IsZero() {
+			s.Accounts[addr] = Account{Balance: bal, Nonce: st.Nonces[addr]}
+		}
+	}
+	for addr, nonce := range st.Nonces {
+		if _, ok := s.Accounts[addr]; !ok && nonce != 0 {
+			s.Accounts[addr] = Account{Nonce: nonce}
+		}
+	}
+	return s
+}
+
+// state returns the state the snapshot holds, set up to apply the block
+// after it.
+func (s *Snapshot) state(p Params) *State {
+	st := NewState()
+	st.Params = p
+	st.Height = s.Height + 1
+	for addr, acc := range s.Accounts {
+		if !acc.Balance.IsZero() {
+			st.Balances[addr] = acc.Balance
+		}
+		if acc.Nonce != 0 {
+			st.Nonces[addr] = acc.Nonce
+		}
+	}
+	for key, amount := range s.Bonds {
+		st.Bonds[key] = amount
+	}
+	for key, amount := range s.Unbonding {
+		st.Unbonding[key] = amount
+	}
+	for key, until := range s.Slashes {
+		st.Slashes[key] = until
+	}
+	return st
+}
+
+// check verifies the snapshot against the state root of b, the block it
+// was taken after.
+func (s *Snapshot) check(b *Block, p Params) error {
+	if s.Hash != b.Hash || s.Height != b.Index {
+		return fmt.Errorf("snapshot of block %d (%s) does not match block %d (%s)", s.Height, s.Hash, b.Index, b.Hash)
+	}
+	if b.StateRoot == "" {
+		return fmt.Errorf("block %d predates state roots, its snapshot can't be checked", b.Index)
+	}
+	if root := s.state(p).Root(); root != b.StateRoot {
+		return fmt.Errorf("snapshot state root %s does not match block %d (%s)", root, b.Index, b.StateRoot)
+	}
+	return nil
+}
+
+func snapshotKey(height int64, hash string) []byte {
+	k := binary.BigEndian.AppendUint64(append([]byte(nil), snapshotPrefix...), uint64(height))
+	return append(k, hash...)
+}
+
+func readSnapshotRefs(db Database) ([]snapshotRef, error) {
+	var refs []snapshotRef
+	err := db.Iterate(snapshotPrefix, func(key, _ []byte) error {
+		key = key[len(snapshotPrefix):]
+		refs = append(refs, snapshotRef{Height: int64(binary.BigEndian.Uint64(key)), Hash: string(key[8:])})
+		return nil
+	})
+	return refs, err
+}
+
+// readSnapshot returns the stored snapshot of main chain block b.
+func (c *Chain) readSnapshot(b *Block) (*Snapshot, error) {
+	if c.blockDB == nil {
+		return nil, ErrNoSnapshot
+	}
+	data, err := c.blockDB.Get(snapshotKey(b.Index, b.Hash))
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("%w of block %d", ErrNoSnapshot, b.Index)
+	} else if err != nil {
+		return nil, err
+	}
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("snapshot of block %d: %w", b.Index, err)
+	}
+	return &s, nil
+}
+
+// latestSnapshot returns the newest snapshot on the main chain at or
+// below height.
+func (c *Chain) latestSnapshot(height int64) (snapshotRef, bool) {
+	for i := len(c.snaps) - 1; i >= 0; i-- {
+		ref := c.snaps[i]
+		if ref.Height <= height && ref.Height < int64(len(c.Blocks)) && c.Blocks[ref.Height].Hash == ref.Hash {
+			return ref, true
+		}
+	}
+	return snapshotRef{}, false
+}
+
+// maintain takes the snapshot due at the tip and prunes the blocks the
+// snapshots made redundant. It runs after every block is committed; the
+// chain is complete without either, so failures are only logged.
+func (c *Chain) maintain() {
+	if c.blockDB == nil {
+		return
+	}
+	tip := c.tip()
+	if n := c.snapshots.Interval; n > 0 && tip.Index > 0 && tip.Index%n == 0 {
+		if err := c.writeSnapshot(tip); err != nil {
+			fmt.Printf("[CHAIN] Cannot take snapshot at block %d: %v\n", tip.Index, err)
+		}
+	}
+	if err := c.prune(); err != nil {
+		fmt.Printf("[CHAIN] Cannot prune blocks: %v\n", err)
+	}
+}
+
+// writeSnapshot stores the current state as the snapshot of the tip.
+func (c *Chain) writeSnapshot(tip *Block) error {
+	batch := c.blockDB.NewBatch()
+	putJSON(batch, snapshotKey(tip.Index, tip.Hash), newSnapshot(tip, c.state()))
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	c.snaps = append(c.snaps, snapshotRef{Height: tip.Index, Hash: tip.Hash})
+	fmt.Printf("[CHAIN] Took snapshot at block %d\n", tip.Index)
+	return nil
+}
+
+// prune drops the transactions and undo data of the main chain blocks up
+// to the newest snapshot that is KeepBodies deep, along with the older
+// snapshots. That snapshot becomes where Verify starts replaying.
+func (c *Chain) prune() error {
+	keep := c.snapshots.KeepBodies
+	if keep <= 0 {
+		return nil
+	}
+	base, ok := c.latestSnapshot(c.tip().Index - keep)
+	if !ok || base.Height < c.bodiesFrom {
+		return nil
+	}
+
+	from := max(c.bodiesFrom, 1)
+	batch := c.blockDB.NewBatch()
+	for _, b := range c.Blocks[from : base.Height+1] {
+		batch.Delete(dbKey(bodyPrefix, b.Hash))
+		batch.Delete(dbKey(undoPrefix, b.Hash))
+	}
+	var kept []snapshotRef
+	for _, ref := range c.snaps {
+		if ref.Height < base.Height {
+			batch.Delete(snapshotKey(ref.Height, ref.Hash))
+		} else {
+			kept = append(kept, ref)
+		}
+	}
+	putJSON(batch, prunedKey, base.Height+1)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	for _, b := range c.Blocks[from : base.Height+1] {
+		b.Transactions = nil
+		delete(c.Undo, b.Hash)
+	}
+	c.snaps = kept
+	c.bodiesFrom = base.Height + 1
+	fmt.Printf("[CHAIN] Pruned blocks %d to %d\n", from, base.Height)
+	return nil
+}
+
+// checkHeader checks what can be checked of a block without its
+// transactions or the state before it: that it links to parent, that
+// its hash covers its header and its seal.
+func checkHeader(b, parent *Block, chainID int64) error {
+	if b.PrevHash != parent.Hash || b.Index != parent.Index+1 {
+		return fmt.Errorf("block does not link to block %d (%s)", parent.Index, parent.Hash)
+	}
+	// Earlier versions hash their transactions along with the header
+	if b.Version >= BlockVersionHeader && b.Hash != b.CalculateHash() {
+		return errors.New("invalid block hash")
+	}
+	return checkSeal(b, chainID)
+}
+
+// appendHeaders checks headers, which continue c's tip, and appends them
+// to c. Proof-of-work headers also have their target recomputed from the
+// ones before them by the engine. Proof-of-stake leaders are drawn from
+// the state before each block, which headers don't come with, so only
+// their signatures are checked. It returns the first header that fails.
+func (c *Chain) appendHeaders(headers []*Block) (*Block, error) {
+	for _, b := range headers {
+		parent := c.tip()
+		if err := checkHeader(b, parent, c.Params.ChainID); err != nil {
+			return b, err
+		}
+		if b.Version >= CurrentBlockVersion {
+			if b.Timestamp < parent.Timestamp {
+				return b, fmt.Errorf("block timestamp %d is before its parent's %d", b.Timestamp, parent.Timestamp)
+			}
+			if c.Params.Engine != EnginePoS {
+				if err := c.Engine.VerifyHeader(chainReader{c}, b, parent); err != nil {
+					return b, err
+				}
+			}
+		}
+		c.Blocks = append(c.Blocks, b)
+		c.byHash[b.Hash] = b
+	}
+	return nil, nil
+}
+
+// snapshotFile is what ExportSnapshot writes: the headers up to a
+// snapshot, the snapshot and the full blocks after it. The rules of the
+// chain are not part of it; the importing node brings its own genesis.
+type snapshotFile struct {
+	Headers  []*Block  `json:"headers"` // Genesis to the snapshot block, without transactions
+	Snapshot *Snapshot `json:"snapshot"`
+	Blocks   []*Block  `json:"blocks"` // Main chain blocks after the snapshot
+}
+
+// ExportSnapshot writes the newest snapshot on the main chain to w,
+// along with the headers before it and the blocks after it, for a new
+// node to start from with ImportSnapshot.
+func (c *Chain) ExportSnapshot(w io.Writer) (*Snapshot, error) {
+	c.mu.Lock()
+	ref, ok := c.latestSnapshot(c.tip().Index)
+	if !ok {
+		c.mu.Unlock()
+		return nil, ErrNoSnapshot
+	}
+	snap, err := c.readSnapshot(c.Blocks[ref.Height])
+	if err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
+	f := snapshotFile{Snapshot: snap}
+	for _, b := range c.Blocks[:ref.Height+1] {
+		f.Headers = append(f.Headers, &Block{Header: b.Header, Hash: b.Hash, Signature: b.Signature})
+	}
+	f.Blocks = append(f.Blocks, c.Blocks[ref.Height+1:]...)
+	c.mu.Unlock()
+
+	return snap, json.NewEncoder(w).Encode(f)
+}
+
+// ImportSnapshot starts a chain at genesis in empty databases from a
+// snapshot written by ExportSnapshot. The headers must link up from
+// genesis's block and pass engine's checks as far as they can without
+// the state before them, and the snapshot must match the state root of
+// its block. The chain runs by genesis's rules with engine attached.
+//
+// As proof-of-stake headers can't be checked against their leaders, a
+// signed history could be made up by anyone who once held stake. Such
+// chains must be pinned by trusted, the hash of the snapshot block or of
+// one of the blocks after it, taken from a source the caller trusts such
+// as the finalized block of a node it runs. Proof-of-work chains may
+// leave it empty.
+//
+// The chain has no bodies up to the snapshot block; the blocks after it
+// are returned for the caller to add.
+func ImportSnapshot(r io.Reader, genesis *Genesis, engine Engine, trusted string, blocks, state Database) (*Chain, []*Block, error) {
+	if engine == nil {
+		return nil, nil, ErrNoEngine
+	}
+	var f snapshotFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, nil, fmt.Errorf("invalid snapshot file: %w", err)
+	}
+	if f.Snapshot == nil || len(f.Headers) == 0 || f.Snapshot.Height != int64(len(f.Headers)-1) {
+		return nil, nil, errors.New("invalid snapshot file: headers don't end at the snapshot")
+	}
+	if err := genesis.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid genesis: %w", err)
+	}
+	p := genesis.Config
+	if p.Engine == EnginePoS && trusted == "" {
+		return nil, nil, errors.New("proof-of-stake snapshots need a trusted block hash")
+	}
+	first := genesis.ToBlock()
+	if first.Hash == DefaultGenesis().ToBlock().Hash && f.Headers[0].Hash == legacyGenesisHash {
+		// The main network chain from chaindata.json predates Genesis
+		// and started out empty, see genesisState
+		genesis = nil
+	} else if f.Headers[0].Hash != first.Hash {
+		return nil, nil, fmt.Errorf("snapshot starts at genesis block %s, not %s", f.Headers[0].Hash, first.Hash)
+	} else {
+		f.Headers[0] = &Block{Header: first.Header, Hash: first.Hash}
+	}
+
+	c := &Chain{
+		Blocks:     f.Headers[:1],
+		Params:     p,
+		Engine:     engine,
+		Undo:       make(map[string]StateUndo),
+		SideBlocks: make(map[string]*Block),
+		genesis:    genesis,
+		byHash:     map[string]*Block{f.Headers[0].Hash: f.Headers[0]},
+	}
+	if bad, err := c.appendHeaders(f.Headers[1:]); err != nil {
+		return nil, nil, fmt.Errorf("header %d: %w", bad.Index, err)
+	}
+	base := c.tip()
+	if err := f.Snapshot.check(base, p); err != nil {
+		return nil, nil, err
+	}
+	if trusted != "" && !pinned(base, f.Blocks, trusted) {
+		return nil, nil, fmt.Errorf("trusted block %s is not the snapshot block or one after it", trusted)
+	}
+
+	c.bodiesFrom = base.Index + 1
+	c.setState(f.Snapshot.state(p))
+	c.indexBlocks()
+	if err := c.Persist(blocks, state); err != nil {
+		return nil, nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.writeSnapshot(base); err != nil {
+		return nil, nil, err
+	}
+	fmt.Printf("[CHAIN] Started from the snapshot at block %d (%s)\n", base.Index, base.Hash)
+	return c, f.Blocks, nil
+}
+
+// pinned reports whether the block with hash trusted is base or one of
+// the blocks after it, linked to it by their hashes.
+func pinned(base *Block, after []*Block, trusted string) bool {
+	trusted = strings.TrimPrefix(strings.ToLower(trusted), "0x")
+	if base.Hash == trusted {
+		return true
+	}
+	parent := base
+	for _, b := range after {
+		if b.PrevHash != parent.Hash || b.Hash != b.CalculateHash() {
+			return false
+		}
+		if b.Hash == trusted {
+			return true
+		}
+		parent = b
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Transaction types. Plain transfers leave Type empty.
+const (
+	TxTransfer = ""
+	// TxStake bonds Amount to the validator at Receiver. Staking to your
+	// own address makes you a validator; staking to another validator
+	// delegates to it.
+	TxStake = "stake"
+	// TxUnstake starts unbonding Amount of the sender's stake with the
+	// validator at Receiver. It is paid back after UnbondingPeriod blocks.
+	TxUnstake = "unstake"
+)
+
+// StakingPoolAddress collects the stakers' share of block rewards until it
+// is paid out at the end of each epoch.
+const StakingPoolAddress = "STAKING_POOL"
+
+var (
+	ErrUnknownValidator  = errors.New("unknown validator")
+	ErrInsufficientStake = errors.New("insufficient stake")
+)
+
+// Validator is an entry of the validator set.
+type Validator struct {
+	Address   string `json:"address"`
+	SelfStake Amount `json:"self_stake"`
+	Power     Amount `json:"power"` // Self stake plus delegations
+}
+
+// Bond is stake a delegator has with a validator, either bonded or on its
+// way back after an unstake.
+type Bond struct {
+	Validator string `json:"validator"`
+	Delegator string `json:"delegator"`
+	Amount    Amount `json:"amount"`
+	Height    int64  `json:"height,omitempty"` // Block unbonding started at
+}
+
+func bondKey(validator, delegator string) string {
+	return accountKey(validator) + "/" + accountKey(delegator)
+}
+
+func unbondKey(height int64, validator, delegator string) string {
+	return strconv.FormatInt(height, 10) + "/" + bondKey(validator, delegator)
+}
+
+func parseBondKey(key string) (validator, delegator string) {
+	validator, delegator, _ = strings.Cut(key, "/")
+	return validator, delegator
+}
+
+func parseUnbondKey(key string) (height int64, validator, delegator string) {
+	h, rest, _ := strings.Cut(key, "/")
+	height, _ = strconv.ParseInt(h, 10, 64)
+	validator, delegator = parseBondKey(rest)
+	return height, validator, delegator
+}
+
+// checkStake checks that a staking transaction can apply. Its receiver,
+// the validator, has been checked to be an address like that of any
+// other transaction.
+func (s *State) checkStake(tx Transaction) error {
+	if tx.Amount.Sign() <= 0 {
+		return errors.New("stake amount must be greater than 0")
+	}
+	switch tx.Type {
+	case TxStake:
+		// Delegations need a validator that has staked itself
+		if accountKey(tx.Receiver) != accountKey(tx.Sender) && s.Bonds[bondKey(tx.Receiver, tx.Receiver)].IsZero() {
+			return fmt.Errorf("%w %s", ErrUnknownValidator, tx.Receiver)
+		}
+	case TxUnstake:
+		if bonded := s.Bonds[bondKey(tx.Receiver, tx.Sender)]; bonded.Cmp(tx.Amount) < 0 {
+			return fmt.Errorf("%w: %s ZAR bonded to %s, unstaking %s ZAR", ErrInsufficientStake, bonded, tx.Receiver, tx.Amount)
+		}
+	default:
+		return fmt.Errorf("unknown transaction type %q", tx.Type)
+	}
+	return nil
+}
+
+// applyStake moves the amount of a checked staking transaction between
+// the sender's bond and its unbonding entry.
+func (s *State) applyStake(tx Transaction) {
+	key := bondKey(tx.Receiver, tx.Sender)
+	if tx.Type == TxStake {
+		s.Bonds[key] = s.Bonds[key].Add(tx.Amount)
+		return
+	}
+	if left := s.Bonds[key].Sub(tx.Amount); left.IsZero() {
+		delete(s.Bonds, key)
+	} else {
+		s.Bonds[key] = left
+	}
+	ukey := unbondKey(s.Height, tx.Receiver, tx.Sender)
+	s.Unbonding[ukey] = s.Unbonding[ukey].Add(tx.Amount)
+}
+
+// Validators returns the validators whose self stake is at least
+// minStake and that aren't jailed, sorted by address.
+func (s *State) Validators(minStake Amount) []Validator {
+	power := make(map[string]Amount)
+	for key, amount := range s.Bonds {
+		validator, _ := parseBondKey(key)
+		power[validator] = power[validator].Add(amount)
+	}
+	var out []Validator
+	for addr, total := range power {
+		self := s.Bonds[bondKey(addr, addr)]
+		if self.IsZero() || self.Cmp(minStake) < 0 || s.jailed(addr) {
+			continue
+		}
+		out = append(out, Validator{Address: addr, SelfStake: self, Power: total})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Address < out[j].Address })
+	return out
+}
+
+// Stakes returns the bonds and unbonding entries of delegator, sorted by
+// validator and height.
+func (s *State) Stakes(delegator string) (bonded, unbonding []Bond) {
+	for key, amount := range s.Bonds {
+		if validator, d := parseBondKey(key); d == accountKey(delegator) {
+			bonded = append(bonded, Bond{Validator: validator, Delegator: d, Amount: amount})
+		}
+	}
+	for key, amount := range s.Unbonding {
+		if height, validator, d := parseUnbondKey(key); d == accountKey(delegator) {
+			unbonding = append(unbonding, Bond{Validator: validator, Delegator: d, Amount: amount, Height: height})
+		}
+	}
+	sort.Slice(bonded, func(i, j int) bool { return bonded[i].Validator < bonded[j].Validator })
+	sort.Slice(unbonding, func(i, j int) bool {
+		if unbonding[i].Height != unbonding[j].Height {
+			return unbonding[i].Height < unbonding[j].Height
+		}
+		return unbonding[i].Validator < unbonding[j].Validator
+	})
+	return bonded, unbonding
+}
+
+// settle runs the end of block height: unbonding stake that has waited
+// UnbondingPeriod blocks is paid back, and at the end of an epoch the
+// staking pool is shared out over the stake bonded to active validators.
+// Rounding dust stays in the pool for the next epoch.
+func (s *State) settle(height int64, p Params) {
+	for key, amount := range s.Unbonding {
+		start, _, delegator := parseUnbondKey(key)
+		if start+p.UnbondingPeriod <= height {
+			s.credit(delegator, amount)
+			delete(s.Unbonding, key)
+		}
+	}
+
+	pool := s.Balance(StakingPoolAddress)
+	if height%p.EpochLength != 0 || pool.IsZero() {
+		return
+	}
+	active := make(map[string]bool)
+	for _, v := range s.Validators(p.MinValidatorStake) {
+		active[v.Address] = true
+	}
+	var total Amount
+	for key, amount := range s.Bonds {
+		if validator, _ := parseBondKey(key); active[validator] {
+			total = total.Add(amount)
+		}
+	}
+	if total.IsZero() {
+		return
+	}
+
+	var paid Amount
+	for key, amount := range s.Bonds {
+		validator, delegator := parseBondKey(key)
+		if !active[validator] {
+			continue
+		}
+		share := pool.MulDiv(amount, total)
+		s.credit(delegator, share)
+		paid = paid.Add(share)
+	}
+	s.Balances[accountKey(StakingPoolAddress)] = pool.Sub(paid)
+}
+
+// Validators returns the active validator set.
+func (c *Chain) Validators() []Validator {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state().Validators(c.Params.MinValidatorStake)
+}
+
+// Stakes returns the bonds and unbonding entries of delegator.
+func (c *Chain) Stakes(delegator string) (bonded, unbonding []Bond) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state().Stakes(delegator)
+}
@@ -0,0 +1,293 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrNegativeAmount      = errors.New("negative amount")
+)
+
+// State holds account balances and nonces keyed by lowercase address,
+// and the stake bonded to validators. Blocks are applied to a scratch
+// copy of the chain state and the result is only committed once every
+// transaction in the block has applied.
+type State struct {
+	Balances  map[string]Amount
+	Nonces    map[string]uint64
+	Bonds     map[string]Amount // By bondKey(validator, delegator)
+	Unbonding map[string]Amount // By unbondKey(height, validator, delegator)
+	Slashes   map[string]int64  // Jailed-until height by slashKey(offence height, validator)
+
+	Height int64  // Index of the block being applied, which unbonding starts at
+	Params Params // Rules for staking and slashing
+}
+
+func NewState() *State {
+	return &State{
+		Balances:  make(map[string]Amount),
+		Nonces:    make(map[string]uint64),
+		Bonds:     make(map[string]Amount),
+		Unbonding: make(map[string]Amount),
+		Slashes:   make(map[string]int64),
+		Params:    DefaultParams(),
+	}
+}
+
+// Copy returns an independent copy of the state.
+func (s *State) Copy() *State {
+	cp := NewState()
+	for addr, bal := range s.Balances {
+		cp.Balances[addr] = bal
+	}
+	for addr, nonce := range s.Nonces {
+		cp.Nonces[addr] = nonce
+	}
+	for key, amount := range s.Bonds {
+		cp.Bonds[key] = amount
+	}
+	for key, amount := range s.Unbonding {
+		cp.Unbonding[key] = amount
+	}
+	for key, until := range s.Slashes {
+		cp.Slashes[key] = until
+	}
+	cp.Height, cp.Params = s.Height, s.Params
+	return cp
+}
+
+func (s *State) Balance(addr string) Amount {
+	return s.Balances[accountKey(addr)]
+}
+
+func (s *State) Nonce(addr string) uint64 {
+	return s.Nonces[accountKey(addr)]
+}
+
+func (s *State) credit(addr string, amount Amount) {
+	key := accountKey(addr)
+	s.Balances[key] = s.Balances[key].Add(amount)
+}
+
+// ApplyTransaction checks tx against the current state and applies it,
+// crediting its fee to coinbase. On error the state is left unchanged.
+func (s *State) ApplyTransaction(tx Transaction, coinbase string) error {
+	if tx.Amount.Sign() < 0 || tx.Fee.Sign() < 0 {
+		return fmt.Errorf("transaction %s: %w", tx.ID, ErrNegativeAmount)
+	}
+	if err := tx.checkReceiver(); err != nil {
+		return fmt.Errorf("transaction %s from %s: %w", tx.ID, tx.Sender, err)
+	}
+	if tx.Type != TxTransfer {
+		if IsSystemSender(tx.Sender) {
+			return fmt.Errorf("transaction %s: block rewards must be transfers", tx.ID)
+		}
+		var err error
+		if tx.Type == TxEvidence {
+			err = s.checkEvidence(tx)
+		} else {
+			err = s.checkStake(tx)
+		}
+		if err != nil {
+			return fmt.Errorf("transaction %s from %s: %w", tx.ID, tx.Sender, err)
+		}
+	}
+
+	// Block rewards mint new coins and are debited from no one. A block
+	// can only carry the rewards its engine computes, see checkRewards;
+	// every other sender pays for what it sends
+	if IsSystemSender(tx.Sender) {
+		if !tx.Fee.IsZero() {
+			return fmt.Errorf("transaction %s: block rewards cannot pay fees", tx.ID)
+		}
+	} else {
+		sender := accountKey(tx.Sender)
+		if want := s.Nonces[sender]; tx.Nonce != want {
+			return fmt.Errorf("transaction %s from %s: %w: got %d, want %d", tx.ID, tx.Sender, ErrInvalidNonce, tx.Nonce, want)
+		}
+		cost := tx.Fee
+		if tx.Type == TxTransfer || tx.Type == TxStake {
+			cost = cost.Add(tx.Amount)
+		}
+		if bal := s.Balances[sender]; bal.Cmp(cost) < 0 {
+			return fmt.Errorf("transaction %s from %s: %w: have %s ZAR, need %s ZAR", tx.ID, tx.Sender, ErrInsufficientBalance, bal, cost)
+		}
+		s.Balances[sender] = s.Balances[sender].Sub(cost)
+		s.Nonces[sender]++
+		if !tx.Fee.IsZero() {
+			s.credit(coinbase, tx.Fee)
+		}
+	}
+
+	switch tx.Type {
+	case TxEvidence:
+		s.slash(tx)
+		return nil
+	case TxStake, TxUnstake:
+		s.applyStake(tx)
+		return nil
+	}
+
+	if IsSystemSender(tx.Sender) || accountKey(tx.Sender) == accountKey(s.Params.FeeRecipient) {
+		s.credit(tx.Receiver, tx.Amount)
+		return nil
+	}
+
+	// Apply the developer fee to regular user transactions
+	fee := tx.Amount.Bps(s.Params.FeeBps)
+	s.credit(tx.Receiver, tx.Amount.Sub(fee))
+	s.credit(s.Params.FeeRecipient, fee)
+	return nil
+}
+
+// ApplyTransactions applies txs in order, stopping at the first invalid
+// one. Callers that need all-or-nothing semantics apply to a Copy.
+func (s *State) ApplyTransactions(txs []Transaction, coinbase string) error {
+	for _, tx := range txs {
+		if err := s.ApplyTransaction(tx, coinbase); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalize merges entries whose keys differ only in letter case, as
+// written by older nodes that stored checksummed addresses verbatim.
+func (s *State) normalize() {
+	balances := make(map[string]Amount, len(s.Balances))
+	for addr, bal := range s.Balances {
+		key := accountKey(addr)
+		balances[key] = balances[key].Add(bal)
+	}
+	nonces := make(map[string]uint64, len(s.Nonces))
+	for addr, nonce := range s.Nonces {
+		key := accountKey(addr)
+		if nonce > nonces[key] {
+			nonces[key] = nonce
+		}
+	}
+	s.Balances, s.Nonces = balances, nonces
+	if s.Bonds == nil {
+		s.Bonds = make(map[string]Amount)
+	}
+	if s.Unbonding == nil {
+		s.Unbonding = make(map[string]Amount)
+	}
+	if s.Slashes == nil {
+		s.Slashes = make(map[string]int64)
+	}
+}
+
+// Account is the state of a single address.
+type Account struct {
+	Balance Amount `json:"balance"`
+	Nonce   uint64 `json:"nonce"`
+}
+
+// StateUndo holds every entry a block changed as it was before the
+// block, so the block can be reverted. Entries the block created are
+// recorded as zero.
+type StateUndo struct {
+	Accounts  map[string]Account `json:"accounts,omitempty"`
+	Bonds     map[string]Amount  `json:"bonds,omitempty"`
+	Unbonding map[string]Amount  `json:"unbonding,omitempty"`
+	Slashes   map[string]int64   `json:"slashes,omitempty"`
+}
+
+// UnmarshalJSON also accepts undo data written before staking, which was
+// a plain map of accounts.
+func (u *StateUndo) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for key := range fields {
+		switch key {
+		case "accounts", "bonds", "unbonding", "slashes":
+		default:
+			*u = StateUndo{}
+			return json.Unmarshal(data, &u.Accounts)
+		}
+	}
+	type plain StateUndo
+	return json.Unmarshal(data, (*plain)(u))
+}
+
+// undoTo returns what has to be restored to go from next back to s.
+func (s *State) undoTo(next *State) StateUndo {
+	undo := StateUndo{Accounts: make(map[string]Account)}
+	record := func(addr string) {
+		if _, ok := undo.Accounts[addr]; ok {
+			return
+		}
+		if s.Balances[addr].Cmp(next.Balances[addr]) != 0 || s.Nonces[addr] != next.Nonces[addr] {
+			undo.Accounts[addr] = Account{Balance: s.Balances[addr], Nonce: s.Nonces[addr]}
+		}
+	}
+	for addr := range next.Balances {
+		record(addr)
+	}
+	for addr := range next.Nonces {
+		record(addr)
+	}
+	sameAmount := func(a, b Amount) bool { return a.Cmp(b) == 0 }
+	undo.Bonds = mapUndo(s.Bonds, next.Bonds, sameAmount)
+	undo.Unbonding = mapUndo(s.Unbonding, next.Unbonding, sameAmount)
+	undo.Slashes = mapUndo(s.Slashes, next.Slashes, func(a, b int64) bool { return a == b })
+	return undo
+}
+
+// mapUndo returns the entries of prev that differ in next, with the zero
+// value for entries only next has.
+func mapUndo[V any](prev, next map[string]V, equal func(a, b V) bool) map[string]V {
+	var undo map[string]V
+	record := func(key string) {
+		if equal(prev[key], next[key]) {
+			return
+		}
+		if undo == nil {
+			undo = make(map[string]V)
+		}
+		undo[key] = prev[key]
+	}
+	for key := range prev {
+		record(key)
+	}
+	for key := range next {
+		record(key)
+	}
+	return undo
+}
+
+// Revert restores the entries in undo.
+func (s *State) Revert(undo StateUndo) {
+	for addr, acc := range undo.Accounts {
+		if acc.Balance.IsZero() {
+			delete(s.Balances, addr)
+		} else {
+			s.Balances[addr] = acc.Balance
+		}
+		if acc.Nonce == 0 {
+			delete(s.Nonces, addr)
+		} else {
+			s.Nonces[addr] = acc.Nonce
+		}
+	}
+	isZero := func(a Amount) bool { return a.IsZero() }
+	revertMap(s.Bonds, undo.Bonds, isZero)
+	revertMap(s.Unbonding, undo.Unbonding, isZero)
+	revertMap(s.Slashes, undo.Slashes, func(until int64) bool { return until == 0 })
+}
+
+func revertMap[V any](m, undo map[string]V, isZero func(V) bool) {
+	for key, v := range undo {
+		if isZero(v) {
+			delete(m, key)
+		} else {
+			m[key] = v
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// The chain is kept in two databases. The block store holds data that
+// never changes once written: headers and transactions by block hash,
+// every block by height and the undo data of blocks that were applied.
+// The state store holds what every block changes: the accounts and
+// stakes, the head, finality and the mempool. A block is committed by
+// writing it to the block store and then its state changes and the new
+// head in one batch, so the state store always describes a block whose
+// data is stored. Until then the block is only a side block, and a node
+// that stopped in between applies it again when the chain is loaded.
+//
+// The block store also keeps state snapshots, below the newest of which
+// block bodies and undo data may be pruned, see snapshot.go.
+var (
+	headerPrefix   = []byte("h") // headerPrefix + hash -> storedHeader
+	bodyPrefix     = []byte("b") // bodyPrefix + hash -> transactions
+	heightPrefix   = []byte("n") // heightPrefix + height (uint64 big endian) + hash -> nothing
+	undoPrefix     = []byte("u") // undoPrefix + hash -> StateUndo
+	snapshotPrefix = []byte("s") // snapshotPrefix + height (uint64 big endian) + hash -> Snapshot
+	prunedKey      = []byte("mpruned")
+
+	accountPrefix   = []byte("a") // accountPrefix + address -> Account
+	bondPrefix      = []byte("B") // bondPrefix + bondKey -> Amount
+	unbondingPrefix = []byte("U") // unbondingPrefix + unbondKey -> Amount
+	slashPrefix     = []byte("S") // slashPrefix + slashKey -> jailed-until height
+
+	headKey     = []byte("mhead")
+	paramsKey   = []byte("mparams")
+	genesisKey  = []byte("mgenesis")
+	finalityKey = []byte("mfinality")
+	mempoolKey  = []byte("mmempool")
+)
+
+var (
+	ErrGenesisMismatch = errors.New("stored chain has a different genesis")
+	// ErrStateRootMismatch is returned by OpenChain along with the chain
+	// when the stored state doesn't match the head's state root, so the
+	// chain can still be verified and its state rebuilt.
+	ErrStateRootMismatch = errors.New("stored state does not match the head's state root")
+	ErrCorrupt           = errors.New("stored chain is corrupt")
+)
+
+// storedHeader is a block without its transactions.
+type storedHeader struct {
+	Header
+	Hash      string `json:"hash"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// storedFinality is the finality gadget's state.
+type storedFinality struct {
+	Votes     map[string]Vote `json:"votes,omitempty"`
+	Finalized string          `json:"finalized,omitempty"`
+	Safe      string          `json:"safe,omitempty"`
+}
+
+func dbKey(prefix []byte, key string) []byte {
+	return append(append([]byte(nil), prefix...), key...)
+}
+
+func heightKey(height int64, hash string) []byte {
+	k := binary.BigEndian.AppendUint64(append([]byte(nil), heightPrefix...), uint64(height))
+	return append(k, hash...)
+}
+
+func putJSON(batch Batch, key []byte, v interface{}) {
+	data, _ := json.Marshal(v)
+	batch.Put(key, data)
+}
+
+// HasChain reports whether a chain was stored in state.
+func HasChain(state Database) (bool, error) {
+	return state.Has(headKey)
+}
+
+// OpenChain loads the chain kept in the blocks and state databases, or
+// starts one at genesis if they are empty. A stored chain must have the
+// same genesis unless genesis is nil.
+func OpenChain(blocks, state Database, genesis *Genesis) (*Chain, error) {
+	ok, err := HasChain(state)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if genesis == nil {
+			genesis = DefaultGenesis()
+		}
+		c, err := NewChainFromGenesis(genesis)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Persist(blocks, state); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+
+	c, err := loadChain(blocks, state)
+	if c == nil {
+		return nil, err
+	}
+	if genesis != nil {
+		if want := genesis.ToBlock().Hash; c.Blocks[0].Hash != want {
+			return nil, fmt.Errorf("%w: %s, expected %s", ErrGenesisMismatch, c.Blocks[0].Hash, want)
+		}
+	}
+	return c, err
+}
+
+func loadChain(blocks, state Database) (*Chain, error) {
+	c := &Chain{
+		Params:     DefaultParams(),
+		Undo:       make(map[string]StateUndo),
+		SideBlocks: make(map[string]*Block),
+		blockDB:    blocks,
+		stateDB:    state,
+	}
+	if data, err := state.Get(paramsKey); err != nil {
+		return nil, fmt.Errorf("reading chain parameters: %w", err)
+	} else if err := json.Unmarshal(data, &c.Params); err != nil {
+		return nil, fmt.Errorf("reading chain parameters: %w", err)
+	}
+	c.Params = c.Params.withDefaults()
+	var err error
+	if data, err := state.Get(genesisKey); err == nil {
+		c.genesis = &Genesis{Config: DefaultParams()}
+		if err := json.Unmarshal(data, c.genesis); err != nil {
+			return nil, fmt.Errorf("reading genesis: %w", err)
+		}
+	}
+
+	if data, err := blocks.Get(prunedKey); err == nil {
+		if err := json.Unmarshal(data, &c.bodiesFrom); err != nil {
+			return nil, fmt.Errorf("reading pruning height: %w", err)
+		}
+	}
+	if c.snaps, err = readSnapshotRefs(blocks); err != nil {
+		return nil, err
+	}
+
+	all := make(map[string]*Block)
+	err = blocks.Iterate(heightPrefix, func(key, _ []byte) error {
+		hash := string(key[len(heightPrefix)+8:])
+		b, err := readBlock(blocks, hash)
+		if err != nil {
+			return err
+		}
+		all[hash] = b
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := state.Get(headKey)
+	if err != nil {
+		return nil, err
+	}
+	for hash := string(head); ; {
+		b, ok := all[hash]
+		if !ok {
+			return nil, fmt.Errorf("main chain block %s is missing", hash)
+		}
+		c.Blocks = append(c.Blocks, b)
+		delete(all, hash)
+		if b.Index == 0 {
+			break
+		}
+		hash = b.PrevHash
+	}
+	for i, j := 0, len(c.Blocks)-1; i < j; i, j = i+1, j-1 {
+		c.Blocks[i], c.Blocks[j] = c.Blocks[j], c.Blocks[i]
+	}
+	if c.bodiesFrom < 0 || c.bodiesFrom > int64(len(c.Blocks)) {
+		return nil, fmt.Errorf("%w: pruning height %d is outside the chain of %d blocks", ErrCorrupt, c.bodiesFrom, len(c.Blocks))
+	}
+	for _, b := range c.Blocks[c.bodiesFrom:] {
+		if b.Transactions == nil {
+			return nil, fmt.Errorf("transactions of block %d (%s) are missing", b.Index, b.Hash)
+		}
+	}
+	for hash, b := range all {
+		c.SideBlocks[hash] = b
+	}
+
+	err = blocks.Iterate(undoPrefix, func(key, value []byte) error {
+		var undo StateUndo
+		if err := json.Unmarshal(value, &undo); err != nil {
+			return fmt.Errorf("undo data of %s: %w", key[len(undoPrefix):], err)
+		}
+		c.Undo[string(key[len(undoPrefix):])] = undo
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := readState(state)
+	if err != nil {
+		return nil, err
+	}
+	c.setState(st)
+
+	var fin storedFinality
+	if data, err := state.Get(finalityKey); err == nil {
+		if err := json.Unmarshal(data, &fin); err != nil {
+			return nil, fmt.Errorf("reading finality: %w", err)
+		}
+	}
+	c.Votes, c.Finalized, c.Safe = fin.Votes, fin.Finalized, fin.Safe
+	if data, err := state.Get(mempoolKey); err == nil {
+		json.Unmarshal(data, &c.SavedPending)
+	}
+
+	c.indexBlocks()
+	if tip := c.tip(); tip.StateRoot != "" {
+		if root := c.state().Root(); root != tip.StateRoot {
+			return c, fmt.Errorf("%w: %s, block %d has %s", ErrStateRootMismatch, root, tip.Index, tip.StateRoot)
+		}
+	}
+	if err := c.recover(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// recover finishes commits that were interrupted after the block batch:
+// their blocks were loaded as side blocks, and if they make a branch with
+// more work than the head, the chain reorgs to it as it would have.
+func (c *Chain) recover() error {
+	best := c.tip()
+	for _, b := range c.SideBlocks {
+		if b.ChainWork.Cmp(best.ChainWork) > 0 {
+			best = b
+		}
+	}
+	if best == c.tip() {
+		return nil
+	}
+	fmt.Printf("[CHAIN] Replaying blocks up to %d (%s) stored after the last state commit\n", best.Index, best.Hash)
+	if err := c.reorg(best); err != nil {
+		// The node can go on from the head if the branch was rejected,
+		// but not with a state it couldn't store
+		if c.tip() == best {
+			return err
+		}
+		fmt.Printf("[CHAIN] Not replaying block %d: %v\n", best.Index, err)
+	}
+	return nil
+}
+
+func readBlock(db Database, hash string) (*Block, error) {
+	data, err := db.Get(dbKey(headerPrefix, hash))
+	if err != nil {
+		return nil, fmt.Errorf("header of block %s: %w", hash, err)
+	}
+	var h storedHeader
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, fmt.Errorf("header of block %s: %w", hash, err)
+	}
+	b := &Block{Header: h.Header, Hash: h.Hash, Signature: h.Signature}
+	// Pruned blocks are left without transactions
+	if data, err = db.Get(dbKey(bodyPrefix, hash)); errors.Is(err, ErrNotFound) {
+		return b, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("transactions of block %s: %w", hash, err)
+	}
+	if err := json.Unmarshal(data, &b.Transactions); err != nil {
+		return nil, fmt.Errorf("transactions of block %s: %w", hash, err)
+	}
+	return b, nil
+}
+
+func readState(db Database) (*State, error) {
+	st := NewState()
+	err := db.Iterate(accountPrefix, func(key, value []byte) error {
+		var acc Account
+		if err := json.Unmarshal(value, &acc); err != nil {
+			return fmt.Errorf("account %s: %w", key[len(accountPrefix):], err)
+		}
+		addr := string(key[len(accountPrefix):])
+		if !acc.Balance.IsZero() {
+			st.Balances[addr] = acc.Balance
+		}
+		if acc.Nonce != 0 {
+			st.Nonces[addr] = acc.Nonce
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range []struct {
+		prefix []byte
+		into   map[string]Amount
+	}{{bondPrefix, st.Bonds}, {unbondingPrefix, st.Unbonding}} {
+		err := db.Iterate(m.prefix, func(key, value []byte) error {
+			var amount Amount
+			if err := json.Unmarshal(value, &amount); err != nil {
+				return fmt.Errorf("stake %s: %w", key[len(m.prefix):], err)
+			}
+			m.into[string(key[len(m.prefix):])] = amount
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = db.Iterate(slashPrefix, func(key, value []byte) error {
+		var until int64
+		if err := json.Unmarshal(value, &until); err != nil {
+			return fmt.Errorf("slash %s: %w", key[len(slashPrefix):], err)
+		}
+		st.Slashes[string(key[len(slashPrefix):])] = until
+		return nil
+	})
+	return st, err
+}
+
+// Persist writes the whole chain to empty databases and keeps them up to
+// date from then on. It is how new and imported chains get stored.
+func (c *Chain) Persist(blocks, state Database) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	batch := blocks.NewBatch()
+	for _, b := range c.Blocks {
+		writeBlock(batch, b)
+		if undo, ok := c.Undo[b.Hash]; ok {
+			putJSON(batch, dbKey(undoPrefix, b.Hash), undo)
+		}
+	}
+	for _, b := range c.SideBlocks {
+		writeBlock(batch, b)
+	}
+	if c.bodiesFrom > 0 {
+		putJSON(batch, prunedKey, c.bodiesFrom)
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	batch = state.NewBatch()
+	writeState(batch, c.state())
+	putJSON(batch, paramsKey, c.Params)
+	if c.genesis != nil {
+		putJSON(batch, genesisKey, c.genesis)
+	}
+	c.writeHead(batch)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	c.blockDB, c.stateDB = blocks, state
+	return nil
+}
+
+// writeState writes every entry of st.
+func writeState(batch Batch, st *State) {
+	for addr := range st.Balances {
+		writeAccount(batch, st, addr)
+	}
+	for addr := range st.Nonces {
+		writeAccount(batch, st, addr)
+	}
+	for key := range st.Bonds {
+		writeAmount(batch, bondPrefix, st.Bonds, key)
+	}
+	for key := range st.Unbonding {
+		writeAmount(batch, unbondingPrefix, st.Unbonding, key)
+	}
+	for key := range st.Slashes {
+		writeSlash(batch, st, key)
+	}
+}
+
+// writeBlock writes the header and, unless it was pruned, the body of b.
+func writeBlock(batch Batch, b *Block) {
+	putJSON(batch, dbKey(headerPrefix, b.Hash), storedHeader{Header: b.Header, Hash: b.Hash, Signature: b.Signature})
+	if b.Transactions != nil {
+		putJSON(batch, dbKey(bodyPrefix, b.Hash), b.Transactions)
+	}
+	batch.Put(heightKey(b.Index, b.Hash), nil)
+}
+
+func writeAccount(batch Batch, st *State, addr string) {
+	acc := Account{Balance: st.Balances[addr], Nonce: st.Nonces[addr]}
+	if acc.Balance.IsZero() && acc.Nonce == 0 {
+		batch.Delete(dbKey(accountPrefix, addr))
+		return
+	}
+	putJSON(batch, dbKey(accountPrefix, addr), acc)
+}
+
+func writeAmount(batch Batch, prefix []byte, m map[string]Amount, key string) {
+	if amount, ok := m[key]; ok && !amount.IsZero() {
+		putJSON(batch, dbKey(prefix, key), amount)
+		return
+	}
+	batch.Delete(dbKey(prefix, key))
+}
+
+func writeSlash(batch Batch, st *State, key string) {
+	if until, ok := st.Slashes[key]; ok {
+		putJSON(batch, dbKey(slashPrefix, key), until)
+		return
+	}
+	batch.Delete(dbKey(slashPrefix, key))
+}
+
+// deleteBlocks removes discarded blocks from the block store.
+func (c *Chain) deleteBlocks(hashes []string) error {
+	if c.blockDB == nil {
+		return nil
+	}
+	batch := c.blockDB.NewBatch()
+	for _, hash := range hashes {
+		b := c.byHash[hash]
+		batch.Delete(dbKey(headerPrefix, hash))
+		batch.Delete(dbKey(bodyPrefix, hash))
+		batch.Delete(heightKey(b.Index, hash))
+	}
+	return batch.Write()
+}
+
+// writeHead records the tip, finality and mempool in a state batch.
+func (c *Chain) writeHead(batch Batch) {
+	batch.Put(headKey, []byte(c.tip().Hash))
+	putJSON(batch, finalityKey, storedFinality{Votes: c.Votes, Finalized: c.Finalized, Safe: c.Safe})
+	putJSON(batch, mempoolKey, c.pendingTxs())
+}
+
+// saveBlocks writes blocks to the block store, with their undo data if
+// they are on the main chain. Nothing is written without a store.
+func (c *Chain) saveBlocks(blocks ...*Block) error {
+	if c.blockDB == nil {
+		return nil
+	}
+	batch := c.blockDB.NewBatch()
+	for _, b := range blocks {
+		writeBlock(batch, b)
+		if undo, ok := c.Undo[b.Hash]; ok {
+			putJSON(batch, dbKey(undoPrefix, b.Hash), undo)
+		}
+	}
+	return batch.Write()
+}
+
+// saveState writes the state entries the blocks with the given undo data
+// changed, along with the head, in one batch. If that fails, the entries
+// are written with the next batch.
+func (c *Chain) saveState(undos ...StateUndo) error {
+	if c.stateDB == nil {
+		return nil
+	}
+	undos = append(c.unsaved, undos...)
+	c.unsaved = nil
+	batch := c.stateDB.NewBatch()
+	st := c.state()
+	for _, undo := range undos {
+		for addr := range undo.Accounts {
+			writeAccount(batch, st, addr)
+		}
+		for key := range undo.Bonds {
+			writeAmount(batch, bondPrefix, st.Bonds, key)
+		}
+		for key := range undo.Unbonding {
+			writeAmount(batch, unbondingPrefix, st.Unbonding, key)
+		}
+		for key := range undo.Slashes {
+			writeSlash(batch, st, key)
+		}
+	}
+	c.writeHead(batch)
+	if err := batch.Write(); err != nil {
+		c.unsaved = undos
+		return err
+	}
+	return nil
+}
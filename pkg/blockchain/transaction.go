@@ -0,0 +1,171 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"zar-blockchain/pkg/wallet"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// MainnetChainID is the chain ID of the ZAR main network (0x7a5).
+const MainnetChainID = 1957
+
+var (
+	ErrMissingSignature = errors.New("transaction is not signed")
+	ErrInvalidSignature = errors.New("signature does not match sender")
+	ErrRawTxMismatch    = errors.New("transaction fields do not match signed raw transaction")
+	ErrInvalidNonce     = errors.New("invalid nonce")
+	ErrInvalidReceiver  = errors.New("receiver is not a hex address")
+)
+
+// IsSystemSender reports whether addr is the sender of block rewards.
+// Only the consensus engine creates these transactions, at the end of
+// every block, and they carry no signature. Anything else that pays out
+// coins, like the faucet and the bridge, sends signed transfers from a
+// funded account.
+func IsSystemSender(addr string) bool {
+	return addr == "SYSTEM"
+}
+
+// accountKey normalizes an address for use as a state key.
+func accountKey(addr string) string {
+	return strings.ToLower(addr)
+}
+
+// checkReceiver checks that a user transaction goes to a hex address.
+// Block rewards may also pay named accounts, like a treasury configured
+// by name.
+func (tx *Transaction) checkReceiver() error {
+	if !IsSystemSender(tx.Sender) && !common.IsHexAddress(tx.Receiver) {
+		return fmt.Errorf("%w: %q", ErrInvalidReceiver, tx.Receiver)
+	}
+	return nil
+}
+
+// txPayload is the signed content of a transaction.
+type txPayload struct {
+	ChainID   int64  `json:"chain_id,omitempty"`
+	ID        string `json:"id"`
+	Type      string `json:"type,omitempty"`
+	Sender    string `json:"sender"`
+	Receiver  string `json:"receiver"`
+	Amount    Amount `json:"amount"`
+	Fee       Amount `json:"fee"`
+	Nonce     uint64 `json:"nonce"`
+	Timestamp int64  `json:"timestamp"`
+
+	Evidence *DoubleSignEvidence `json:"evidence,omitempty"`
+}
+
+// SigningPayload returns the canonical bytes a sender signs for the chain
+// chainID. Every field except the signature is covered, plus the chain ID
+// so a signature can't be replayed on another network.
+func (tx *Transaction) SigningPayload(chainID int64) []byte {
+	p := tx.payload()
+	p.ChainID = chainID
+	data, _ := json.Marshal(p)
+	return data
+}
+
+func (tx *Transaction) payload() txPayload {
+	return txPayload{
+		ID:        tx.ID,
+		Type:      tx.Type,
+		Sender:    tx.Sender,
+		Receiver:  tx.Receiver,
+		Amount:    tx.Amount,
+		Fee:       tx.Fee,
+		Nonce:     tx.Nonce,
+		Timestamp: tx.Timestamp,
+		Evidence:  tx.Evidence,
+	}
+}
+
+// Hash returns the transaction's unique identifier. Wallet-originated
+// transactions keep the Ethereum hash of their signed envelope so that
+// MetaMask can look them up; everything else hashes its canonical payload
+// and signature, which binds it to its chain ID.
+func (tx *Transaction) Hash() string {
+	if tx.RawTx != "" {
+		if raw, err := hex.DecodeString(strings.TrimPrefix(tx.RawTx, "0x")); err == nil {
+			return crypto.Keccak256Hash(raw).Hex()
+		}
+	}
+	data, _ := json.Marshal(tx.payload())
+	return crypto.Keccak256Hash(data, []byte(tx.Signature)).Hex()
+}
+
+// Size returns the encoded size of the transaction in bytes, which is what
+// counts towards MaxBlockBytes.
+func (tx *Transaction) Size() int {
+	data, _ := json.Marshal(tx)
+	return len(data)
+}
+
+// Sign signs the transaction with w for the chain chainID. The sender must
+// be w's address.
+func (tx *Transaction) Sign(w *wallet.Wallet, chainID int64) error {
+	if !wallet.SameAddress(tx.Sender, w.Address) {
+		return fmt.Errorf("wallet %s cannot sign for sender %s", w.Address, tx.Sender)
+	}
+	sig, err := w.Sign(tx.SigningPayload(chainID))
+	if err != nil {
+		return err
+	}
+	tx.Signature = sig
+	return nil
+}
+
+// VerifySignature checks that a user transaction was signed for the chain
+// chainID by the key owning tx.Sender. Transactions submitted through
+// eth_sendRawTransaction are checked against the Ethereum envelope they
+// were decoded from. Block rewards are always accepted; checkRewards
+// matches them against the engine's.
+func (tx *Transaction) VerifySignature(chainID int64) error {
+	if IsSystemSender(tx.Sender) {
+		return nil
+	}
+	if tx.RawTx != "" {
+		return tx.verifyRawTx(chainID)
+	}
+	if tx.Signature == "" {
+		return ErrMissingSignature
+	}
+	if !wallet.VerifySignature(tx.Sender, tx.SigningPayload(chainID), tx.Signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (tx *Transaction) verifyRawTx(chainID int64) error {
+	raw, err := hex.DecodeString(strings.TrimPrefix(tx.RawTx, "0x"))
+	if err != nil {
+		return fmt.Errorf("invalid raw transaction: %v", err)
+	}
+	ethTx, err := wallet.DecodeEthTx(raw)
+	if err != nil {
+		return fmt.Errorf("invalid raw transaction: %v", err)
+	}
+	from, err := ethTx.Sender(chainID)
+	if err != nil {
+		return err
+	}
+	if !wallet.SameAddress(from, tx.Sender) {
+		return ErrInvalidSignature
+	}
+	// Ethereum envelopes can only carry plain transfers
+	if tx.Type != TxTransfer {
+		return ErrRawTxMismatch
+	}
+	if !wallet.SameAddress(ethTx.To, tx.Receiver) || ethTx.Nonce != tx.Nonce ||
+		NewAmount(ethTx.Value).Cmp(tx.Amount) != 0 || NewAmount(ethTx.Fee).Cmp(tx.Fee) != 0 {
+		return ErrRawTxMismatch
+	}
+	return nil
+}
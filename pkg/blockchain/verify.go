@@ -0,0 +1,251 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// VerifyReport is the outcome of replaying a chain with Verify.
+type VerifyReport struct {
+	Blocks     int64    // Blocks after genesis that verified
+	Divergent  *Block   // First main chain block that failed, nil if none did
+	Err        error    // Why Divergent failed
+	Mismatches []string // Differences between the stored and the replayed state
+
+	state *State
+	undo  map[string]StateUndo
+}
+
+// OK reports whether every block verified and the stored state matched.
+func (r *VerifyReport) OK() bool {
+	return r.Divergent == nil && len(r.Mismatches) == 0
+}
+
+// Verify replays the main chain from genesis without trusting anything
+// stored with it: every block's link, hash, seal and transactions are
+// checked again, the balances, nonces and stakes are recomputed from the
+// blocks and the result is compared with the stored state. The replay
+// stops at the first block that fails. On a pruned chain, the blocks
+// without transactions only get their headers checked and the replay
+// starts from the snapshot they were pruned to.
+func (c *Chain) Verify() (*VerifyReport, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Engine == nil {
+		return nil, ErrNoEngine
+	}
+
+	// The replay builds a chain of its own, so the engine checks every
+	// block against the replayed state of its parent
+	genesis := c.Blocks[0]
+	v := &Chain{
+		Blocks: []*Block{genesis},
+		Params: c.Params,
+		Engine: c.Engine,
+		byHash: map[string]*Block{genesis.Hash: genesis},
+	}
+	r := &VerifyReport{undo: make(map[string]StateUndo)}
+	if c.bodiesFrom > 1 {
+		bad, err := v.appendHeaders(c.Blocks[1:c.bodiesFrom])
+		r.Blocks = int64(len(v.Blocks) - 1)
+		if err != nil {
+			r.Divergent, r.Err = bad, err
+			return r, nil
+		}
+		base := v.tip()
+		snap, err := c.readSnapshot(base)
+		if err == nil {
+			err = snap.check(base, c.Params)
+		}
+		if err != nil {
+			r.Divergent, r.Err = base, err
+			return r, nil
+		}
+		v.setState(snap.state(c.Params))
+	} else {
+		v.setState(c.genesisState())
+		if root := v.state().Root(); genesis.StateRoot != "" && genesis.StateRoot != root {
+			r.Divergent = genesis
+			r.Err = fmt.Errorf("state root %s does not match the genesis state (%s)", genesis.StateRoot, root)
+			return r, nil
+		}
+	}
+
+	for _, b := range c.Blocks[len(v.Blocks):] {
+		st := v.state().Copy()
+		if err := v.replay(st, b); err != nil {
+			r.Divergent, r.Err = b, err
+			return r, nil
+		}
+		r.undo[b.Hash] = v.state().undoTo(st)
+		v.setState(st)
+		v.Blocks = append(v.Blocks, b)
+		v.byHash[b.Hash] = b
+		r.Blocks++
+	}
+	r.state = v.state()
+	r.Mismatches = diffState(c.state(), r.state)
+	return r, nil
+}
+
+// genesisState returns the state the genesis block starts from. Chains
+// imported from chaindata.json started out empty.
+func (c *Chain) genesisState() *State {
+	g := c.genesis
+	if g == nil {
+		for _, network := range []string{Mainnet, Testnet, Devnet} {
+			if n := NetworkGenesis(network); n.ToBlock().Hash == c.Blocks[0].Hash {
+				g = n
+			}
+		}
+	}
+	if g != nil {
+		return g.state()
+	}
+	st := NewState()
+	st.Params = c.Params
+	return st
+}
+
+// replay checks b against the tip of the replayed chain and applies it
+// to st.
+func (c *Chain) replay(st *State, b *Block) error {
+	parent := c.tip()
+	if b.PrevHash != parent.Hash || b.Index != parent.Index+1 {
+		return fmt.Errorf("block does not link to block %d (%s)", parent.Index, parent.Hash)
+	}
+	if b.Hash != b.CalculateHash() {
+		return errors.New("invalid block hash")
+	}
+	if b.Version >= CurrentBlockVersion {
+		if b.Timestamp < parent.Timestamp {
+			return fmt.Errorf("block timestamp %d is before its parent's %d", b.Timestamp, parent.Timestamp)
+		}
+		if err := c.Engine.VerifyHeader(chainReader{c}, b, parent); err != nil {
+			return err
+		}
+		if err := c.verifyBody(b); err != nil {
+			return err
+		}
+	} else {
+		if err := checkSeal(b, c.Params.ChainID); err != nil {
+			return err
+		}
+		for _, tx := range b.Transactions {
+			if err := tx.VerifySignature(c.Params.ChainID); err != nil {
+				return fmt.Errorf("invalid transaction %s from %s: %w", tx.ID, tx.Sender, err)
+			}
+		}
+	}
+	return applyBlock(st, b, c.Params)
+}
+
+// checkSeal checks that a block was signed by its validator for the chain
+// chainID or, without one, mined. It is all that can be checked of the consensus fields of
+// blocks from before the consensus engines, and of blocks without the
+// state before them.
+func checkSeal(b *Block, chainID int64) error {
+	if err := checkDifficulty(b); err != nil {
+		return err
+	}
+	if b.Validator != "" {
+		return b.VerifySignature(chainID)
+	}
+	if HashToBig(b.Hash).Cmp(b.Target()) > 0 {
+		return errors.New("block hash does not meet its target")
+	}
+	return nil
+}
+
+// diffState describes every entry in which stored differs from replayed.
+func diffState(stored, replayed *State) []string {
+	var diffs []string
+	for _, m := range []struct {
+		name           string
+		stored, replay map[string]Amount
+	}{
+		{"balance", stored.Balances, replayed.Balances},
+		{"stake", stored.Bonds, replayed.Bonds},
+		{"unbonding", stored.Unbonding, replayed.Unbonding},
+	} {
+		for _, key := range unionKeys(m.stored, m.replay) {
+			if a, b := m.stored[key], m.replay[key]; a.Cmp(b) != 0 {
+				diffs = append(diffs, fmt.Sprintf("%s of %s: stored %s ZAR, replayed %s ZAR", m.name, key, a, b))
+			}
+		}
+	}
+	for _, key := range unionKeys(stored.Nonces, replayed.Nonces) {
+		if a, b := stored.Nonces[key], replayed.Nonces[key]; a != b {
+			diffs = append(diffs, fmt.Sprintf("nonce of %s: stored %d, replayed %d", key, a, b))
+		}
+	}
+	for _, key := range unionKeys(stored.Slashes, replayed.Slashes) {
+		a, okA := stored.Slashes[key]
+		b, okB := replayed.Slashes[key]
+		if a != b || okA != okB {
+			diffs = append(diffs, fmt.Sprintf("slash %s: stored %d, replayed %d", key, a, b))
+		}
+	}
+	return diffs
+}
+
+func unionKeys[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// RebuildState replaces the state and the undo data of the main chain
+// with those of a replay in which every block verified, and stores them.
+func (c *Chain) RebuildState(r *VerifyReport) error {
+	if r.Divergent != nil {
+		return fmt.Errorf("cannot rebuild the state of a chain that fails at block %d", r.Divergent.Index)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.blockDB != nil {
+		batch := c.blockDB.NewBatch()
+		for hash, undo := range r.undo {
+			putJSON(batch, dbKey(undoPrefix, hash), undo)
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+	}
+	for hash, undo := range r.undo {
+		c.Undo[hash] = undo
+	}
+	c.setState(r.state)
+	if c.stateDB == nil {
+		return nil
+	}
+
+	// Entries missing from the replayed state are deleted in the same
+	// batch, before the replayed ones are written
+	batch := c.stateDB.NewBatch()
+	for _, prefix := range [][]byte{accountPrefix, bondPrefix, unbondingPrefix, slashPrefix} {
+		err := c.stateDB.Iterate(prefix, func(key, _ []byte) error {
+			batch.Delete(key)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writeState(batch, r.state)
+	c.writeHead(batch)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	c.unsaved = nil
+	return nil
+}
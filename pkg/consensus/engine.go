@@ -0,0 +1,46 @@
+package consensus
+
+import (
+	"fmt"
+	"zar-blockchain/pkg/blockchain"
+	"zar-blockchain/pkg/wallet"
+)
+
+// Engine is a consensus algorithm driven by blockchain.Chain. The
+// interface is declared in pkg/blockchain so the chain can hold an engine
+// without importing this package.
+type Engine = blockchain.Engine
+
+// New returns the engine selected by p.Engine. signer is the validator key
+// this node produces proof-of-stake blocks with, or nil.
+func New(p blockchain.Params, signer *wallet.Wallet) (Engine, error) {
+	switch p.Engine {
+	case blockchain.EnginePoW, "":
+		return NewPoW(), nil
+	case blockchain.EnginePoS:
+		return NewPoS(signer, p.ChainID), nil
+	}
+	return nil, fmt.Errorf("unknown consensus engine %q", p.Engine)
+}
+
+// blockRewards splits the block reward between the block's coinbase, the
+// staking pool and the treasury (the remainder), after the developer fee.
+// The pool is paid out to the stakers at the end of each epoch.
+func blockRewards(p blockchain.Params, header *blockchain.Header) []blockchain.Transaction {
+	reward := p.RewardAt(header.Index)
+	devFee := reward.Bps(p.FeeBps)
+	remainingReward := reward.Sub(devFee)
+
+	minerReward := remainingReward.MulFrac(p.MinerShare, 100)
+	stakerReward := remainingReward.MulFrac(p.StakerShare, 100)
+	// Treasury takes the remainder so no wei is lost to rounding
+	treasuryReward := remainingReward.Sub(minerReward).Sub(stakerReward)
+
+	height := header.Index
+	return []blockchain.Transaction{
+		{ID: fmt.Sprintf("miner-reward-%d", height), Sender: "SYSTEM", Receiver: header.Coinbase, Amount: minerReward},
+		{ID: fmt.Sprintf("staker-reward-%d", height), Sender: "SYSTEM", Receiver: blockchain.StakingPoolAddress, Amount: stakerReward},
+		{ID: fmt.Sprintf("treasury-reward-%d", height), Sender: "SYSTEM", Receiver: p.TreasuryAddress, Amount: treasuryReward},
+		{ID: fmt.Sprintf("dev-fee-%d", height), Sender: "SYSTEM", Receiver: p.FeeRecipient, Amount: devFee},
+	}
+}
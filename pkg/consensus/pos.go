@@ -0,0 +1,217 @@
+package consensus
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+	"zar-blockchain/pkg/blockchain"
+	"zar-blockchain/pkg/wallet"
+)
+
+// maxLeaderRounds bounds how far ahead Prepare looks for a round led by
+// the local validator.
+const maxLeaderRounds = 1024
+
+var (
+	ErrNotLeader    = errors.New("this node is not the validator for the next block")
+	ErrNoValidators = errors.New("no active validators")
+)
+
+// PoS is proof-of-stake. Time after a block is split into rounds of
+// BlockPeriod seconds, and each round has one leader drawn by stake from
+// the validator set in the parent's state, so a missing leader only
+// delays the chain by a round.
+//
+// The draw is seeded with the parent's randomness. Every block mixes the
+// output of its validator's VRF over that randomness into its own, and as
+// a VRF has exactly one output per key and input, the validator can't
+// grind the seed for later rounds; it can only withhold its block.
+type PoS struct {
+	signer  *wallet.Wallet // Key this node produces blocks with, nil if none
+	chainID int64          // Chain the signer's blocks are signed for
+}
+
+func NewPoS(signer *wallet.Wallet, chainID int64) *PoS {
+	return &PoS{signer: signer, chainID: chainID}
+}
+
+// Prepare schedules the header in the first round from now that the local
+// validator leads.
+func (e *PoS) Prepare(chain blockchain.ChainReader, header *blockchain.Header, parent *blockchain.Block) error {
+	if e.signer == nil {
+		return ErrNotLeader
+	}
+	p := chain.Config()
+	validators, err := activeValidators(chain, parent)
+	if err != nil {
+		return err
+	}
+	seed := randomness(parent)
+
+	first := roundAt(parent, header.Timestamp, p.BlockPeriod)
+	for round := first; round < first+maxLeaderRounds; round++ {
+		leader := SelectValidator(validators, slotSeed(seed, header.Index, round))
+		if !wallet.SameAddress(leader, e.signer.Address) {
+			continue
+		}
+		out, proof, err := e.signer.VRFProve(vrfInput(seed, header.Index))
+		if err != nil {
+			return err
+		}
+		header.Validator = leader
+		header.Bits = 0
+		header.Timestamp = parent.Timestamp + p.BlockPeriod*(round+1)
+		header.VRFProof = hex.EncodeToString(proof)
+		header.Randomness = nextRandomness(seed, out)
+		return nil
+	}
+	return ErrNotLeader
+}
+
+// Seal waits until the block's round starts and returns it signed. Only
+// the round's leader can seal; other nodes get ErrNotLeader straight away.
+func (e *PoS) Seal(ctx context.Context, block *blockchain.Block) (*blockchain.Block, error) {
+	if e.signer == nil || !wallet.SameAddress(block.Validator, e.signer.Address) {
+		return nil, ErrNotLeader
+	}
+	if wait := time.Until(time.Unix(block.Timestamp, 0)); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	b := *block
+	b.Hash = b.CalculateHash()
+	if err := b.Sign(e.signer, e.chainID); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (e *PoS) VerifyHeader(chain blockchain.ChainReader, block *blockchain.Block, parent *blockchain.Block) error {
+	p := chain.Config()
+	if block.Bits != 0 || block.Nonce != 0 {
+		return errors.New("proof-of-stake blocks carry no proof-of-work")
+	}
+	if earliest := parent.Timestamp + p.BlockPeriod; block.Timestamp < earliest {
+		return fmt.Errorf("block timestamp %d is before its first round at %d", block.Timestamp, earliest)
+	}
+	// A block from a round that hasn't started would let its validator
+	// skip ahead of the current leader
+	if block.Timestamp > time.Now().Unix()+p.BlockPeriod {
+		return fmt.Errorf("block timestamp %d is in a future round", block.Timestamp)
+	}
+
+	validators, err := activeValidators(chain, parent)
+	if err != nil {
+		return err
+	}
+	seed := randomness(parent)
+	round := roundAt(parent, block.Timestamp, p.BlockPeriod)
+	if leader := SelectValidator(validators, slotSeed(seed, block.Index, round)); !wallet.SameAddress(block.Validator, leader) {
+		return fmt.Errorf("block produced by %s, expected validator %s for round %d", block.Validator, leader, round)
+	}
+	if err := block.VerifySignature(p.ChainID); err != nil {
+		return err
+	}
+
+	proof, err := hex.DecodeString(block.VRFProof)
+	if err != nil {
+		return fmt.Errorf("invalid VRF proof: %w", err)
+	}
+	out, err := wallet.VRFVerify(block.Validator, vrfInput(seed, block.Index), proof)
+	if err != nil {
+		return err
+	}
+	if want := nextRandomness(seed, out); block.Randomness != want {
+		return fmt.Errorf("block randomness %s does not match its VRF output (%s)", block.Randomness, want)
+	}
+	return nil
+}
+
+func (e *PoS) Finalize(chain blockchain.ChainReader, header *blockchain.Header) []blockchain.Transaction {
+	return blockRewards(chain.Config(), header)
+}
+
+func activeValidators(chain blockchain.ChainReader, parent *blockchain.Block) ([]blockchain.Validator, error) {
+	st, err := chain.StateAt(parent)
+	if err != nil {
+		return nil, err
+	}
+	validators := st.Validators(chain.Config().MinValidatorStake)
+	if len(validators) == 0 {
+		return nil, ErrNoValidators
+	}
+	return validators, nil
+}
+
+// roundAt returns the round after parent that timestamp falls in. Round 0
+// starts BlockPeriod seconds after the parent.
+func roundAt(parent *blockchain.Block, timestamp, period int64) int64 {
+	round := (timestamp-parent.Timestamp)/period - 1
+	if round < 0 {
+		return 0
+	}
+	return round
+}
+
+// randomness returns the seed b leaves for electing the next leader.
+// Blocks without one, such as genesis, contribute their hash.
+func randomness(b *blockchain.Block) []byte {
+	if seed, err := hex.DecodeString(b.Randomness); err == nil && len(seed) == sha256.Size {
+		return seed
+	}
+	h := sha256.Sum256([]byte(b.Hash))
+	return h[:]
+}
+
+func nextRandomness(seed, vrfOutput []byte) string {
+	h := sha256.Sum256(append(append([]byte(nil), seed...), vrfOutput...))
+	return hex.EncodeToString(h[:])
+}
+
+// vrfInput is what the validator of block index evaluates its VRF on. The
+// round is left out, so leading several rounds gives no extra outputs to
+// choose from.
+func vrfInput(seed []byte, index int64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte(nil), seed...), uint64(index))
+}
+
+func slotSeed(seed []byte, index, round int64) []byte {
+	data := binary.BigEndian.AppendUint64(append([]byte(nil), seed...), uint64(index))
+	data = binary.BigEndian.AppendUint64(data, uint64(round))
+	h := sha256.Sum256(data)
+	return h[:]
+}
+
+// SelectValidator draws a validator with probability proportional to its
+// power. The draw is fully determined by seed and the validator list,
+// which must be in a canonical order (State.Validators sorts by address).
+func SelectValidator(validators []blockchain.Validator, seed []byte) string {
+	total := new(big.Int)
+	for _, v := range validators {
+		total.Add(total, v.Power.Wei())
+	}
+	if total.Sign() == 0 {
+		return ""
+	}
+
+	r := new(big.Int).SetBytes(seed)
+	r.Mod(r, total)
+	for _, v := range validators {
+		power := v.Power.Wei()
+		if r.Cmp(power) < 0 {
+			return v.Address
+		}
+		r.Sub(r, power)
+	}
+	return ""
+}
@@ -0,0 +1,168 @@
+package consensus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"zar-blockchain/pkg/blockchain"
+)
+
+// hashBatch is how many hashes a worker tries between checks for
+// cancellation and updates of the shared hash counter.
+const hashBatch = 1024
+
+// PoW is Nakamoto-style proof-of-work: a block is sealed by finding a
+// nonce whose header hash is at most the target, and the target is
+// retargeted from block timestamps.
+type PoW struct {
+	workers atomic.Int64
+	hashes  atomic.Uint64 // Total hashes computed while sealing
+}
+
+func NewPoW() *PoW {
+	e := &PoW{}
+	e.workers.Store(1)
+	return e
+}
+
+// SetWorkers sets how many goroutines Seal searches the nonce space with.
+func (e *PoW) SetWorkers(n int) {
+	if n < 1 {
+		n = 1
+	}
+	e.workers.Store(int64(n))
+}
+
+// Hashes returns the number of hashes computed so far.
+func (e *PoW) Hashes() uint64 {
+	return e.hashes.Load()
+}
+
+func (e *PoW) Prepare(chain blockchain.ChainReader, header *blockchain.Header, parent *blockchain.Block) error {
+	header.Bits = CalcNextBits(ancestors(chain, parent), chain.Config())
+	if header.Bits != parent.TargetBits() {
+		fmt.Printf("[NETWORK] Target adjusted: bits %08x -> %08x\n", parent.TargetBits(), header.Bits)
+	}
+	return nil
+}
+
+// Seal searches for a nonce that meets block's target, splitting the
+// nonce space evenly between the workers.
+func (e *PoW) Seal(ctx context.Context, block *blockchain.Block) (*blockchain.Block, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := e.workers.Load()
+	span := math.MaxInt64 / workers
+	target := block.Target()
+	found := make(chan *blockchain.Block, 1)
+
+	var wg sync.WaitGroup
+	for i := int64(0); i < workers; i++ {
+		wg.Add(1)
+		go func(first int64) {
+			defer wg.Done()
+			b := *block
+			for n := int64(0); n < span; n++ {
+				if n%hashBatch == 0 {
+					if ctx.Err() != nil {
+						return
+					}
+					e.hashes.Add(hashBatch)
+				}
+				b.Nonce = first + n
+				b.Hash = b.CalculateHash()
+				if blockchain.HashToBig(b.Hash).Cmp(target) <= 0 {
+					select {
+					case found <- &b:
+						cancel()
+					default:
+					}
+					return
+				}
+			}
+		}(i * span)
+	}
+	wg.Wait()
+
+	select {
+	case b := <-found:
+		return b, nil
+	default:
+		return nil, ctx.Err()
+	}
+}
+
+func (e *PoW) VerifyHeader(chain blockchain.ChainReader, block *blockchain.Block, parent *blockchain.Block) error {
+	if block.Validator != "" || block.Signature != "" || block.VRFProof != "" || block.Randomness != "" {
+		return errors.New("proof-of-work blocks carry no validator fields")
+	}
+	// The target is derived from the block's own branch, not taken from it
+	if want := CalcNextBits(ancestors(chain, parent), chain.Config()); block.Bits != want {
+		return fmt.Errorf("invalid target bits %08x, expected %08x", block.Bits, want)
+	}
+	if blockchain.HashToBig(block.Hash).Cmp(block.Target()) > 0 {
+		return errors.New("block hash does not meet its target")
+	}
+	return nil
+}
+
+func (e *PoW) Finalize(chain blockchain.ChainReader, header *blockchain.Header) []blockchain.Transaction {
+	return blockRewards(chain.Config(), header)
+}
+
+// ancestors returns up to RetargetInterval blocks of parent's branch,
+// ending with parent.
+func ancestors(chain blockchain.ChainReader, parent *blockchain.Block) []*blockchain.Block {
+	n := chain.Config().RetargetInterval
+	if parent.Index+1 < n {
+		n = parent.Index + 1
+	}
+	window := make([]*blockchain.Block, n)
+	b := parent
+	for i := n - 1; i >= 0; i-- {
+		window[i] = b
+		if i > 0 {
+			b = chain.GetBlock(b.PrevHash)
+		}
+	}
+	return window
+}
+
+// CalcNextBits returns the target required for the block that follows the
+// last entry of blocks. Every RetargetInterval blocks the target is scaled
+// by how long the previous interval actually took against the expected
+// time, by at most 4x either way and never above PowLimitBits.
+func CalcNextBits(blocks []*blockchain.Block, p blockchain.Params) uint32 {
+	parent := blocks[len(blocks)-1]
+	next := parent.Index + 1
+	if next%p.RetargetInterval != 0 || int64(len(blocks)) < p.RetargetInterval {
+		return parent.TargetBits()
+	}
+
+	first := blocks[int64(len(blocks))-p.RetargetInterval]
+	actual := parent.Timestamp - first.Timestamp
+	expected := p.TargetBlockTime * (p.RetargetInterval - 1)
+	if actual < expected/4 {
+		actual = expected / 4
+	}
+	if actual > expected*4 {
+		actual = expected * 4
+	}
+
+	target := parent.Target()
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+
+	if limit := blockchain.CompactToBig(p.PowLimitBits); target.Cmp(limit) > 0 {
+		target = limit
+	}
+	if target.Sign() <= 0 {
+		target = big.NewInt(1)
+	}
+	return blockchain.BigToCompact(target)
+}
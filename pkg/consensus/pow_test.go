@@ -0,0 +1,93 @@
+package consensus
+
+import (
+	"math/big"
+	"testing"
+
+	"zar-blockchain/pkg/blockchain"
+)
+
+// window returns a retarget window of p.RetargetInterval blocks at bits
+// ending at a retarget boundary and spanning elapsed seconds.
+func window(p blockchain.Params, bits uint32, elapsed int64) []*blockchain.Block {
+	n := p.RetargetInterval
+	blocks := make([]*blockchain.Block, n)
+	for i := range blocks {
+		blocks[i] = &blockchain.Block{Header: blockchain.Header{
+			Version:   blockchain.CurrentBlockVersion,
+			Index:     n + int64(i),
+			Timestamp: 1000 + elapsed*int64(i)/(n-1),
+			Bits:      bits,
+		}}
+	}
+	return blocks
+}
+
+// scaled returns the compact form of bits' target times num/den.
+func scaled(bits uint32, num, den int64) uint32 {
+	t := blockchain.CompactToBig(bits)
+	t.Mul(t, big.NewInt(num))
+	t.Div(t, big.NewInt(den))
+	return blockchain.BigToCompact(t)
+}
+
+func TestCalcNextBits(t *testing.T) {
+	p := blockchain.DefaultParams()
+	expected := p.TargetBlockTime * (p.RetargetInterval - 1)
+	bits := blockchain.DifficultyToBits(4)
+
+	tests := []struct {
+		name    string
+		bits    uint32
+		elapsed int64
+		want    uint32
+	}{
+		{"on time", bits, expected, bits},
+		{"twice as slow", bits, 2 * expected, scaled(bits, 2, 1)},
+		{"half the time", bits, expected / 2, scaled(bits, expected/2, expected)},
+		{"too fast, clamped to 4x harder", bits, 1, scaled(bits, expected/4, expected)},
+		{"no time at all", bits, 0, scaled(bits, expected/4, expected)},
+		{"too slow, clamped to 4x easier", bits, 100 * expected, scaled(bits, 4, 1)},
+		{"easier than the limit", p.PowLimitBits, 4 * expected, p.PowLimitBits},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CalcNextBits(window(p, tt.bits, tt.elapsed), p); got != tt.want {
+				t.Errorf("CalcNextBits = %08x, want %08x", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCalcNextBitsBetweenRetargets checks that the target only changes at
+// retarget boundaries with a full window behind them.
+func TestCalcNextBitsBetweenRetargets(t *testing.T) {
+	p := blockchain.DefaultParams()
+	bits := blockchain.DifficultyToBits(4)
+
+	blocks := window(p, bits, 1)
+	for _, b := range blocks {
+		b.Index++
+	}
+	if got := CalcNextBits(blocks, p); got != bits {
+		t.Errorf("off a boundary: CalcNextBits = %08x, want %08x", got, bits)
+	}
+	if got := CalcNextBits(window(p, bits, 1)[1:], p); got != bits {
+		t.Errorf("short window: CalcNextBits = %08x, want %08x", got, bits)
+	}
+}
+
+// TestCalcNextBitsLegacyParent checks that a window of legacy blocks
+// retargets from the target their difficulty stands for.
+func TestCalcNextBitsLegacyParent(t *testing.T) {
+	p := blockchain.DefaultParams()
+	expected := p.TargetBlockTime * (p.RetargetInterval - 1)
+	blocks := window(p, 0, 2*expected)
+	for _, b := range blocks {
+		b.Version = blockchain.BlockVersionWei
+		b.Difficulty = 4
+	}
+	if got, want := CalcNextBits(blocks, p), scaled(blockchain.DifficultyToBits(4), 2, 1); got != want {
+		t.Errorf("CalcNextBits = %08x, want %08x", got, want)
+	}
+}
@@ -1,33 +1,54 @@
 package gateway
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 	"zar-blockchain/pkg/blockchain"
+	"zar-blockchain/pkg/storage"
+	"zar-blockchain/pkg/wallet"
 )
 
 type BridgeOrder struct {
-	ID             string  `json:"id"`
-	Chain          string  `json:"chain"`          // BTC, ETH, SOL
-	DepositAddress string  `json:"depositAddress"` // Address user sends crypto to
-	ZARAddress     string  `json:"zarAddress"`      // User's MetaMask address
-	Status         string  `json:"status"`          // pending, completed, expired
-	AmountIn       float64 `json:"amountIn"`        // External crypto amount
-	AmountOut      float64 `json:"amountOut"`       // ZAR amount minted
-	CreatedAt      int64   `json:"createdAt"`
+	ID             string            `json:"id"`
+	Chain          string            `json:"chain"`              // BTC, ETH, SOL
+	DepositAddress string            `json:"depositAddress"`     // Address user sends crypto to
+	ZARAddress     string            `json:"zarAddress"`         // User's MetaMask address
+	Status         string            `json:"status"`             // pending, deposited, confirming, completed, failed, expired
+	AmountIn       float64           `json:"amountIn"`           // External crypto amount
+	AmountOut      blockchain.Amount `json:"amountOut"`          // ZAR amount paid out
+	PayoutTx       string            `json:"payoutTx,omitempty"` // Hash of the payout transaction
+	CreatedAt      int64             `json:"createdAt"`
+	// DepositHeight is the ZAR height the deposit was seen at. The payout
+	// is only sent once the chain is final up to it, see WatchFinality.
+	// Payout is the payout transaction, signed once sent
+	DepositHeight int64                   `json:"depositHeight,omitempty"`
+	Payout        *blockchain.Transaction `json:"payout,omitempty"`
 }
 
 type Gateway struct {
 	Chain             *blockchain.Chain
 	Fee               float64
 	Oracle            *PriceOracle
+	Wallet            *wallet.Wallet          // Funded account the payouts are sent from
 	ExternalReceivers map[string]string       // Maps Deposit Address -> User's ZAR Address
 	BridgeOrders      map[string]*BridgeOrder // Maps Order ID -> BridgeOrder
+	Path              string                  // File the orders are saved to, see Load
 	mu                sync.Mutex
 }
 
+// savedOrders is the content of the gateway's order file.
+type savedOrders struct {
+	Receivers map[string]string       `json:"receivers"`
+	Orders    map[string]*BridgeOrder `json:"orders"`
+}
+
 var SupportedChains = map[string]string{
 	"BTC":   "bitcoin",
 	"ETH":   "ethereum",
@@ -66,6 +87,37 @@ func NewGateway(chain *blockchain.Chain, fee float64) *Gateway {
 	}
 }
 
+// Load reads the orders saved in path, if any, and saves them there from
+// then on.
+func (g *Gateway) Load(path string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		saved := savedOrders{Receivers: g.ExternalReceivers, Orders: g.BridgeOrders}
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return fmt.Errorf("%s is corrupt: %w", path, err)
+		}
+		fmt.Printf("[BRIDGE] Loaded %d bridge orders\n", len(g.BridgeOrders))
+	}
+	g.Path = path
+	return nil
+}
+
+// save writes the orders to Path. The caller holds g.mu.
+func (g *Gateway) save() {
+	if g.Path == "" {
+		return
+	}
+	data, _ := json.Marshal(savedOrders{Receivers: g.ExternalReceivers, Orders: g.BridgeOrders})
+	if err := storage.WriteFile(g.Path, data, 0600); err != nil {
+		fmt.Printf("[BRIDGE] Cannot save bridge orders: %v\n", err)
+	}
+}
+
 // GenerateReceiver generates a "deposit address" for a specific chain (BTC, ETH, SOL, etc.)
 // and links it to the user's ZAR (MetaMask) address. Returns the order ID.
 func (g *Gateway) GenerateReceiver(externalChain string, zarAddress string) string {
@@ -74,12 +126,12 @@ func (g *Gateway) GenerateReceiver(externalChain string, zarAddress string) stri
 
 	chain := strings.ToUpper(externalChain)
 	orderID := fmt.Sprintf("bridge-%s-%d", chain, time.Now().UnixNano())
-	
+
 	// Simulate a deposit address (in production: derive from HD wallet)
 	depositAddr := fmt.Sprintf("%s-RECV-%s-%d", chain, zarAddress[2:8], time.Now().Unix()%10000)
-	
+
 	g.ExternalReceivers[depositAddr] = zarAddress
-	
+
 	order := &BridgeOrder{
 		ID:             orderID,
 		Chain:          chain,
@@ -89,6 +141,7 @@ func (g *Gateway) GenerateReceiver(externalChain string, zarAddress string) stri
 		CreatedAt:      time.Now().Unix(),
 	}
 	g.BridgeOrders[orderID] = order
+	g.save()
 
 	fmt.Printf("[BRIDGE] New %s bridge order: %s -> %s\n", chain, depositAddr, zarAddress)
 	return orderID
@@ -116,6 +169,10 @@ func (g *Gateway) ProcessExternalDeposit(externalChain string, receiverAddr stri
 		fmt.Printf("[GATEWAY] Error: Unknown receiver address %s\n", receiverAddr)
 		return
 	}
+	if g.Wallet == nil {
+		fmt.Println("[GATEWAY] No bridge wallet to pay deposits from")
+		return
+	}
 
 	// Fetch live price
 	coinID, ok := SupportedChains[strings.ToUpper(externalChain)]
@@ -123,48 +180,170 @@ func (g *Gateway) ProcessExternalDeposit(externalChain string, receiverAddr stri
 		fmt.Printf("[GATEWAY] Unsupported chain: %s\n", externalChain)
 		return
 	}
-	
+
 	usdPrice, err := g.Oracle.GetPrice(coinID, "usd")
 	if err != nil {
 		fmt.Printf("[GATEWAY] Price Error: %v. Using fallback.\n", err)
 		usdPrice = 50000.0
 	}
 
-	grossAmount := amount * usdPrice
-	bridgeFee := grossAmount * g.Fee
-	devFee := grossAmount * blockchain.FeePercentage
-	netAmount := grossAmount - bridgeFee - devFee
+	// The payout is a transfer from the bridge wallet, which takes the
+	// developer fee out of it like out of any other transfer
+	grossAmount := blockchain.ZARFromFloat(amount * usdPrice)
+	bridgeFee := grossAmount.Bps(int64(math.Round(g.Fee * 10000)))
+	payout := grossAmount.Sub(bridgeFee)
+	devFee := payout.Bps(g.Chain.Params.FeeBps)
+	netAmount := payout.Sub(devFee)
 
-	fmt.Printf("[BRIDGE] %f %s ($%.2f) -> %f ZAR to %s (fee: $%.2f, dev: $%.4f)\n",
+	fmt.Printf("[BRIDGE] %f %s ($%s) -> %s ZAR to %s (fee: $%s, dev: $%s)\n",
 		amount, externalChain, grossAmount, netAmount, zarAddress, bridgeFee, devFee)
 
-	// Main payout
-	tx := blockchain.Transaction{
-		ID:       fmt.Sprintf("bridge-%s-%d", externalChain, time.Now().Unix()),
-		Sender:   "BRIDGE",
-		Receiver: zarAddress,
-		Amount:   netAmount,
-	}
-	// Developer fee
-	txDev := blockchain.Transaction{
-		ID:       fmt.Sprintf("bridge-dev-%d", time.Now().Unix()),
-		Sender:   "BRIDGE",
-		Receiver: blockchain.DeveloperAddress,
-		Amount:   devFee,
-	}
-	g.Chain.Mempool = append(g.Chain.Mempool, tx, txDev)
-
-	// Update bridge order status
+	// Record the deposit. The payout is sent once the chain is final up
+	// to here, see WatchFinality
+	now := time.Now()
 	g.mu.Lock()
+	defer g.mu.Unlock()
 	for _, order := range g.BridgeOrders {
 		if order.DepositAddress == receiverAddr && order.Status == "pending" {
-			order.Status = "completed"
+			order.Status = "deposited"
 			order.AmountIn = amount
 			order.AmountOut = netAmount
-			break
+			order.DepositHeight = g.Chain.Height()
+			order.Payout = &blockchain.Transaction{
+				ID:        fmt.Sprintf("bridge-%s-%d", externalChain, now.UnixNano()),
+				Receiver:  zarAddress,
+				Amount:    payout,
+				Timestamp: now.Unix(),
+			}
+			g.save()
+			return
 		}
 	}
-	g.mu.Unlock()
+	fmt.Printf("[GATEWAY] No pending order for deposit address %s\n", receiverAddr)
 }
 
+// payoutConfirmations is how many blocks must follow a block before the
+// gateway treats it as final on a chain without validators, where no
+// checkpoint is ever finalized.
+const payoutConfirmations = 6
 
+// finalHeight returns the height up to which the chain is final: that of
+// the finalized checkpoint or, without validators to finalize one, of the
+// block payoutConfirmations below the tip.
+func (g *Gateway) finalHeight() int64 {
+	if len(g.Chain.Validators()) == 0 {
+		return max(g.Chain.Height()-payoutConfirmations, 0)
+	}
+	return g.Chain.FinalizedBlock().Index
+}
+
+// WatchFinality moves bridge orders along as the chain becomes final:
+// deposits the final chain covers are paid out, and orders complete once
+// their payout is final. Until then the block including a payout could
+// still be reorged out.
+func (g *Gateway) WatchFinality() {
+	heads := g.Chain.SubscribeHeads()
+	finalized := g.Chain.SubscribeFinalized()
+	last := g.finalHeight()
+	g.confirmPayouts(last)
+	go func() {
+		for {
+			select {
+			case <-heads:
+			case <-finalized:
+			}
+			if h := g.finalHeight(); h > last {
+				last = h
+				g.confirmPayouts(h)
+			}
+		}
+	}()
+}
+
+// confirmPayouts marks confirming orders whose payout is included at or
+// below the final height as completed, sends the payouts of deposits the
+// final chain covers, and sends again payouts that left both the chain
+// and the mempool, which happens when the mempool drops them after a
+// reorg.
+func (g *Gateway) confirmPayouts(final int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	pending := make(map[string]bool)
+	if g.Chain.Pool != nil {
+		for _, tx := range g.Chain.Pool.Pending() {
+			pending[tx.Hash()] = true
+		}
+	}
+	var lost []*BridgeOrder
+	changed := false
+	for _, order := range g.BridgeOrders {
+		switch order.Status {
+		case "confirming":
+			proof, err := g.Chain.TxProof(order.PayoutTx)
+			if err == nil {
+				if proof.BlockIndex <= final {
+					order.Status = "completed"
+					fmt.Printf("[BRIDGE] Order %s completed, payout final in block %d\n", order.ID, proof.BlockIndex)
+					changed = true
+				}
+			} else if !pending[order.PayoutTx] && order.Payout != nil {
+				lost = append(lost, order)
+			}
+		case "deposited":
+			if order.DepositHeight <= final && g.Wallet != nil {
+				g.sendPayout(order)
+				changed = true
+			}
+		}
+	}
+
+	// Payouts go in again in nonce order, as a gap would hold back the
+	// rest. One whose nonce another transaction has taken can never be
+	// included, so it is signed again with a fresh nonce; any other is
+	// left to try again later
+	sort.Slice(lost, func(i, j int) bool { return lost[i].Payout.Nonce < lost[j].Payout.Nonce })
+	for _, order := range lost {
+		err := g.Chain.AddPendingTransaction(*order.Payout)
+		switch {
+		case err == nil:
+			fmt.Printf("[BRIDGE] Sent payout %s of order %s again\n", order.PayoutTx, order.ID)
+		case g.Wallet != nil && g.Chain.GetNonce(order.Payout.Sender) > order.Payout.Nonce:
+			fmt.Printf("[BRIDGE] Payout %s of order %s lost its nonce, signing it again\n", order.PayoutTx, order.ID)
+			g.sendPayout(order)
+		default:
+			fmt.Printf("[BRIDGE] Cannot send payout %s of order %s again, retrying later: %v\n", order.PayoutTx, order.ID, err)
+		}
+		changed = true
+	}
+	if changed {
+		g.save()
+	}
+}
+
+// sendPayout signs the payout of order with the next nonce of the bridge
+// wallet and queues it. The signed transaction is saved in the order
+// first, so after a crash the order still knows the only payout it can
+// have. If it can't be signed or queued, say as the wallet is short of
+// funds, the order waits until the chain is final further on. The caller
+// holds g.mu and saves the orders.
+func (g *Gateway) sendPayout(order *BridgeOrder) {
+	tx := *order.Payout
+	tx.Sender = g.Wallet.Address
+	tx.Nonce = g.Chain.PendingNonce(g.Wallet.Address)
+	if err := tx.Sign(g.Wallet, g.Chain.Params.ChainID); err != nil {
+		fmt.Printf("[BRIDGE] Cannot sign payout of order %s, retrying later: %v\n", order.ID, err)
+		return
+	}
+	order.Payout = &tx
+	order.PayoutTx = tx.Hash()
+	order.Status = "confirming"
+	g.save()
+	if err := g.Chain.AddPendingTransaction(tx); err != nil {
+		order.Status = "deposited"
+		order.PayoutTx = ""
+		fmt.Printf("[BRIDGE] Cannot queue payout of order %s, retrying later: %v\n", order.ID, err)
+		return
+	}
+	fmt.Printf("[BRIDGE] Sent payout %s of order %s\n", order.PayoutTx, order.ID)
+}
@@ -69,14 +69,12 @@ func (s *Scanner) checkBitcoinDeposit(btcAddr string, zarAddr string) {
 		val := utxo["value"].(float64) / 100000000.0 // Satoshis to BTC
 		fmt.Printf("[SCANNER] REAL BTC DEPOSIT DETECTED: %f BTC to %s\n", val, btcAddr)
 		
-		// 1. Process the deposit (Add to mempool)
+		// Record the deposit on its order
 		s.Gateway.ProcessExternalDeposit("BTC", btcAddr, val)
 
-		// 2. Auto-Mine a new block to "finalize" the ZAR minting
-		// In a real network, this would happen when the next miner finds a block.
-		// For a single-node setup, we trigger it automatically for UX.
-		fmt.Println("[SCANNER] Auto-Mining ZAR block to finalize payout...")
-		s.Gateway.Chain.MinePendingTransactions("GATEWAY_RESERVE", "0xSTAKER", "0xTREASURY")
+		// The payout is queued once validators finalize the chain up to
+		// here, and the order completes once they finalize the payout too
+		// (see WatchFinality). Miners include it like any other transaction.
 	}
 }
 
@@ -0,0 +1,306 @@
+package mempool
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"zar-blockchain/pkg/blockchain"
+)
+
+var (
+	ErrDuplicate   = errors.New("transaction already in mempool")
+	ErrNonceTaken  = errors.New("sender already has a pending transaction with this nonce")
+	ErrPoolFull    = errors.New("mempool is full")
+	ErrSenderLimit = errors.New("too many pending transactions from sender")
+	ErrReward      = errors.New("block rewards are only added by the block producer")
+)
+
+type Config struct {
+	MaxSize      int           // Maximum number of pending transactions
+	MaxPerSender int           // Maximum pending transactions per user account
+	TTL          time.Duration // How long a transaction may wait before it is evicted
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxSize:      4096,
+		MaxPerSender: 64,
+		TTL:          3 * time.Hour,
+	}
+}
+
+type entry struct {
+	tx    blockchain.Transaction
+	hash  string
+	added time.Time
+	seq   uint64 // Arrival order, breaks ties between equal timestamps
+}
+
+// Pool holds transactions waiting to be mined. It is safe for concurrent
+// use by the RPC server, the bridge and the miner.
+type Pool struct {
+	cfg      Config
+	all      map[string]*entry   // tx hash -> entry
+	bySender map[string][]*entry // lowercase sender -> entries sorted by nonce
+	seq      uint64
+	mu       sync.Mutex
+}
+
+func New(cfg Config) *Pool {
+	return &Pool{
+		cfg:      cfg,
+		all:      make(map[string]*entry),
+		bySender: make(map[string][]*entry),
+	}
+}
+
+// Add queues tx. It only enforces pool rules (duplicates, nonce slots and
+// size limits); validity against chain state is the caller's job.
+func (p *Pool) Add(tx blockchain.Transaction) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if blockchain.IsSystemSender(tx.Sender) {
+		return ErrReward
+	}
+	p.evictExpired(time.Now())
+
+	hash := tx.Hash()
+	if _, ok := p.all[hash]; ok {
+		return ErrDuplicate
+	}
+	if p.cfg.MaxSize > 0 && len(p.all) >= p.cfg.MaxSize {
+		return ErrPoolFull
+	}
+
+	p.seq++
+	e := &entry{tx: tx, hash: hash, added: time.Now(), seq: p.seq}
+
+	sender := strings.ToLower(tx.Sender)
+	queue := p.bySender[sender]
+	if p.cfg.MaxPerSender > 0 && len(queue) >= p.cfg.MaxPerSender {
+		return ErrSenderLimit
+	}
+	i := sort.Search(len(queue), func(i int) bool { return queue[i].tx.Nonce >= tx.Nonce })
+	if i < len(queue) && queue[i].tx.Nonce == tx.Nonce {
+		return fmt.Errorf("%w (nonce %d)", ErrNonceTaken, tx.Nonce)
+	}
+	queue = append(queue, nil)
+	copy(queue[i+1:], queue[i:])
+	queue[i] = e
+	p.bySender[sender] = queue
+	p.all[hash] = e
+	return nil
+}
+
+// Has reports whether a transaction with the given hash is pending.
+func (p *Pool) Has(hash string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.all[hash]
+	return ok
+}
+
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.all)
+}
+
+// Remove drops the transactions with the given hashes.
+func (p *Pool) Remove(hashes ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, h := range hashes {
+		p.removeLocked(h)
+	}
+}
+
+// RemoveIncluded drops transactions that were mined in a block.
+func (p *Pool) RemoveIncluded(txs []blockchain.Transaction) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, tx := range txs {
+		p.removeLocked(tx.Hash())
+	}
+}
+
+// Pending returns every queued transaction in mining order: interleaved
+// by arrival while keeping each sender's transactions in nonce order.
+func (p *Pool) Pending() []blockchain.Transaction {
+	return p.Select(0)
+}
+
+// Select returns up to max transactions (0 means all) in mining order.
+func (p *Pool) Select(max int) []blockchain.Transaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.evictExpired(time.Now())
+
+	var out []blockchain.Transaction
+	full := func() bool { return max > 0 && len(out) >= max }
+
+	// Merge the per-sender queues, always taking the head that arrived first
+	heads := make(map[string]int, len(p.bySender))
+	for !full() {
+		var best *entry
+		var bestSender string
+		for sender, queue := range p.bySender {
+			i := heads[sender]
+			if i >= len(queue) {
+				continue
+			}
+			if best == nil || queue[i].seq < best.seq {
+				best, bestSender = queue[i], sender
+			}
+		}
+		if best == nil {
+			break
+		}
+		out = append(out, best.tx)
+		heads[bestSender]++
+	}
+	return out
+}
+
+// SelectForBlock picks transactions for a new block by descending fee,
+// with each sender's transactions kept in nonce order. Selection stops at maxTxs/maxBytes.
+// apply is called on every candidate in selection order; a rejected
+// transaction is dropped from the pool and the rest of its sender's queue
+// is skipped for this block, since it would have a nonce gap.
+func (p *Pool) SelectForBlock(maxTxs, maxBytes int, apply func(blockchain.Transaction) error) []blockchain.Transaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.evictExpired(time.Now())
+
+	var out []blockchain.Transaction
+	var rejected []string
+	size := 0
+
+	// take reports whether e was included and whether selection may go on
+	take := func(e *entry) (bool, bool) {
+		if len(out) >= maxTxs {
+			return false, false
+		}
+		txSize := e.tx.Size()
+		if size+txSize > maxBytes {
+			return false, true
+		}
+		if err := apply(e.tx); err != nil {
+			fmt.Printf("[MEMPOOL] Dropping transaction %s from %s: %v\n", e.tx.ID, e.tx.Sender, err)
+			rejected = append(rejected, e.hash)
+			return false, true
+		}
+		out = append(out, e.tx)
+		size += txSize
+		return true, true
+	}
+
+	byFee := &feeQueue{}
+	for sender, queue := range p.bySender {
+		heap.Push(byFee, &senderHead{sender: sender, queue: queue})
+	}
+	for byFee.Len() > 0 && len(out) < maxTxs {
+		head := heap.Pop(byFee).(*senderHead)
+		ok, more := take(head.entry())
+		if !more {
+			break
+		}
+		if !ok {
+			continue
+		}
+		head.next++
+		if head.next < len(head.queue) {
+			heap.Push(byFee, head)
+		}
+	}
+
+	for _, h := range rejected {
+		p.removeLocked(h)
+	}
+	return out
+}
+
+// senderHead is the next unselected transaction of one sender's queue.
+type senderHead struct {
+	sender string
+	queue  []*entry
+	next   int
+}
+
+func (h *senderHead) entry() *entry { return h.queue[h.next] }
+
+// feeQueue orders sender heads by fee, highest first, then by arrival.
+type feeQueue []*senderHead
+
+func (q feeQueue) Len() int { return len(q) }
+func (q feeQueue) Less(i, j int) bool {
+	a, b := q[i].entry(), q[j].entry()
+	if c := a.tx.Fee.Cmp(b.tx.Fee); c != 0 {
+		return c > 0
+	}
+	return a.seq < b.seq
+}
+func (q feeQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *feeQueue) Push(x interface{}) { *q = append(*q, x.(*senderHead)) }
+func (q *feeQueue) Pop() interface{} {
+	old := *q
+	h := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return h
+}
+
+// Evict removes transactions that have been waiting longer than the TTL
+// and returns how many were dropped.
+func (p *Pool) Evict() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.evictExpired(time.Now())
+}
+
+func (p *Pool) evictExpired(now time.Time) int {
+	if p.cfg.TTL <= 0 {
+		return 0
+	}
+	var expired []string
+	for hash, e := range p.all {
+		if now.Sub(e.added) > p.cfg.TTL {
+			expired = append(expired, hash)
+		}
+	}
+	for _, h := range expired {
+		p.removeLocked(h)
+	}
+	if len(expired) > 0 {
+		fmt.Printf("[MEMPOOL] Evicted %d expired transactions\n", len(expired))
+	}
+	return len(expired)
+}
+
+func (p *Pool) removeLocked(hash string) {
+	e, ok := p.all[hash]
+	if !ok {
+		return
+	}
+	delete(p.all, hash)
+
+	sender := strings.ToLower(e.tx.Sender)
+	queue := p.bySender[sender]
+	for i, s := range queue {
+		if s == e {
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) == 0 {
+		delete(p.bySender, sender)
+	} else {
+		p.bySender[sender] = queue
+	}
+}
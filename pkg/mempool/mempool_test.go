@@ -0,0 +1,174 @@
+package mempool
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"zar-blockchain/pkg/blockchain"
+)
+
+const (
+	alice = "0x00000000000000000000000000000000000000a1"
+	bob   = "0x00000000000000000000000000000000000000b0"
+)
+
+func newTx(sender string, nonce uint64) blockchain.Transaction {
+	return blockchain.Transaction{
+		ID:        fmt.Sprintf("%s-%d", sender, nonce),
+		Sender:    sender,
+		Receiver:  "0x00000000000000000000000000000000000000cc",
+		Amount:    blockchain.ZAR(1),
+		Nonce:     nonce,
+		Signature: "sig",
+	}
+}
+
+func add(t *testing.T, p *Pool, txs ...blockchain.Transaction) {
+	t.Helper()
+	for _, tx := range txs {
+		if err := p.Add(tx); err != nil {
+			t.Fatalf("Add(%s) = %v", tx.ID, err)
+		}
+	}
+}
+
+func ids(txs []blockchain.Transaction) []string {
+	out := make([]string, len(txs))
+	for i, tx := range txs {
+		out[i] = tx.ID
+	}
+	return out
+}
+
+func checkIDs(t *testing.T, got []blockchain.Transaction, want ...string) {
+	t.Helper()
+	if g := fmt.Sprint(ids(got)); g != fmt.Sprint(want) {
+		t.Errorf("got %s, want %v", g, want)
+	}
+}
+
+// TestNonceOrder checks that each sender's transactions come out in nonce
+// order whatever order they arrived in, interleaved with other senders by
+// the arrival of each queue's head.
+func TestNonceOrder(t *testing.T) {
+	p := New(DefaultConfig())
+	add(t, p, newTx(alice, 2), newTx(bob, 0), newTx(alice, 0), newTx(alice, 1))
+	checkIDs(t, p.Pending(), bob+"-0", alice+"-0", alice+"-1", alice+"-2")
+	checkIDs(t, p.Select(2), bob+"-0", alice+"-0")
+
+	// Senders are matched case-insensitively
+	upper := newTx("0x00000000000000000000000000000000000000A1", 1)
+	upper.ID = "other"
+	if err := p.Add(upper); !errors.Is(err, ErrNonceTaken) {
+		t.Errorf("Add with a taken nonce = %v, want ErrNonceTaken", err)
+	}
+	if err := p.Add(newTx(bob, 0)); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Add of a queued transaction = %v, want ErrDuplicate", err)
+	}
+
+	tx := newTx(alice, 1)
+	p.Remove(tx.Hash())
+	checkIDs(t, p.Pending(), bob+"-0", alice+"-0", alice+"-2")
+	p.RemoveIncluded([]blockchain.Transaction{newTx(alice, 0), newTx(alice, 2)})
+	checkIDs(t, p.Pending(), bob+"-0")
+}
+
+func TestLimits(t *testing.T) {
+	p := New(Config{MaxSize: 3, MaxPerSender: 2})
+	add(t, p, newTx(alice, 0), newTx(alice, 1))
+	if err := p.Add(newTx(alice, 2)); !errors.Is(err, ErrSenderLimit) {
+		t.Errorf("Add over the sender limit = %v, want ErrSenderLimit", err)
+	}
+	add(t, p, newTx(bob, 0))
+	if err := p.Add(newTx(bob, 1)); !errors.Is(err, ErrPoolFull) {
+		t.Errorf("Add to a full pool = %v, want ErrPoolFull", err)
+	}
+	reward := newTx("SYSTEM", 0)
+	if err := p.Add(reward); !errors.Is(err, ErrReward) {
+		t.Errorf("Add of a block reward = %v, want ErrReward", err)
+	}
+	if n := p.Len(); n != 3 {
+		t.Errorf("pool holds %d transactions, want 3", n)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	p := New(Config{TTL: time.Hour})
+	add(t, p, newTx(alice, 0), newTx(alice, 1), newTx(bob, 0))
+	old := newTx(alice, 0)
+	p.all[old.Hash()].added = time.Now().Add(-2 * time.Hour)
+
+	if n := p.Evict(); n != 1 {
+		t.Errorf("Evict dropped %d transactions, want 1", n)
+	}
+	checkIDs(t, p.Pending(), alice+"-1", bob+"-0")
+	if p.Has(old.Hash()) {
+		t.Error("expired transaction is still pending")
+	}
+
+	// Reading the pool evicts too
+	stale := newTx(bob, 0)
+	p.all[stale.Hash()].added = time.Now().Add(-2 * time.Hour)
+	checkIDs(t, p.Pending(), alice+"-1")
+	if n := p.Len(); n != 1 {
+		t.Errorf("pool holds %d transactions, want 1", n)
+	}
+}
+
+func withFee(tx blockchain.Transaction, fee float64) blockchain.Transaction {
+	tx.Fee = blockchain.ZARFromFloat(fee)
+	return tx
+}
+
+func applyAll(blockchain.Transaction) error { return nil }
+
+// TestSelectByFee checks that blocks take the best-paying sender head
+// first, never a sender's later nonce before an earlier one.
+func TestSelectByFee(t *testing.T) {
+	p := New(DefaultConfig())
+	carol := "0x00000000000000000000000000000000000000c0"
+	add(t, p,
+		withFee(newTx(alice, 0), 1),
+		withFee(newTx(alice, 1), 9), // Stuck behind alice's cheaper first one
+		withFee(newTx(bob, 0), 5),
+		withFee(newTx(carol, 0), 5), // Same fee as bob's, arrived later
+	)
+	checkIDs(t, p.SelectForBlock(10, 1<<20, applyAll), bob+"-0", carol+"-0", alice+"-0", alice+"-1")
+	checkIDs(t, p.SelectForBlock(2, 1<<20, applyAll), bob+"-0", carol+"-0")
+
+	// Selection leaves the pool alone
+	if n := p.Len(); n != 4 {
+		t.Errorf("pool holds %d transactions, want 4", n)
+	}
+}
+
+func TestSelectSizeLimit(t *testing.T) {
+	p := New(DefaultConfig())
+	big := withFee(newTx(alice, 0), 9)
+	big.Signature = string(make([]byte, 1000))
+	small := withFee(newTx(bob, 0), 1)
+	add(t, p, big, small)
+
+	// A transaction that doesn't fit is passed over for smaller ones
+	checkIDs(t, p.SelectForBlock(10, small.Size()+100, applyAll), bob+"-0")
+	checkIDs(t, p.SelectForBlock(10, big.Size()+small.Size(), applyAll), alice+"-0", bob+"-0")
+	checkIDs(t, p.SelectForBlock(10, small.Size()-1, applyAll))
+}
+
+// TestSelectRejected checks that a transaction apply rejects is dropped
+// and its sender's later ones are left for another block.
+func TestSelectRejected(t *testing.T) {
+	p := New(DefaultConfig())
+	add(t, p, withFee(newTx(alice, 0), 9), withFee(newTx(alice, 1), 9), withFee(newTx(bob, 0), 1))
+	bad := newTx(alice, 0)
+	got := p.SelectForBlock(10, 1<<20, func(tx blockchain.Transaction) error {
+		if tx.ID == bad.ID {
+			return errors.New("overdraft")
+		}
+		return nil
+	})
+	checkIDs(t, got, bob+"-0")
+	checkIDs(t, p.Pending(), alice+"-1", bob+"-0")
+}
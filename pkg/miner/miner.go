@@ -0,0 +1,217 @@
+package miner
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zar-blockchain/pkg/blockchain"
+)
+
+type Config struct {
+	Workers  int           // Parallel sealing goroutines, 0 means one per CPU, at most MaxWorkers
+	Coinbase string        // Receives the miner reward and transaction fees
+	Recommit time.Duration // Minimum time on a block before new transactions restart it
+}
+
+// threaded is implemented by engines that seal with several goroutines
+// and count the hashes they compute, such as proof-of-work.
+type threaded interface {
+	SetWorkers(n int)
+	Hashes() uint64
+}
+
+// MaxWorkers is the most sealing goroutines a miner runs. More than a
+// few per CPU only add scheduling overhead.
+func MaxWorkers() int {
+	return runtime.NumCPU() * 4
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Workers:  runtime.NumCPU(),
+		Recommit: 3 * time.Second,
+	}
+}
+
+// Miner seals blocks on top of the chain tip with the chain's consensus
+// engine. The current work is abandoned as soon as another block extends
+// the chain, and rebuilt when new transactions have been waiting for at
+// least Recommit.
+type Miner struct {
+	chain *blockchain.Chain
+	cfg   Config
+	heads <-chan *blockchain.Block
+	txs   <-chan blockchain.Transaction
+
+	hashRate atomic.Uint64 // math.Float64bits of the last measured rate
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func New(chain *blockchain.Chain, cfg Config) *Miner {
+	if cfg.Workers <= 0 {
+		cfg.Workers = runtime.NumCPU()
+	}
+	cfg.Workers = min(cfg.Workers, MaxWorkers())
+	if cfg.Recommit <= 0 {
+		cfg.Recommit = DefaultConfig().Recommit
+	}
+	return &Miner{
+		chain: chain,
+		cfg:   cfg,
+		heads: chain.SubscribeHeads(),
+		txs:   chain.SubscribeTxs(),
+	}
+}
+
+// Start begins mining in the background. It does nothing if the miner is
+// already running.
+func (m *Miner) Start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel, m.done = cancel, make(chan struct{})
+	fmt.Printf("[MINER] Started with %d workers\n", m.cfg.Workers)
+	go m.loop(ctx, m.done, m.cfg.Workers)
+}
+
+// Stop halts mining and waits for the workers to exit.
+func (m *Miner) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cancel == nil {
+		return
+	}
+	m.cancel()
+	<-m.done
+	m.cancel, m.done = nil, nil
+	m.hashRate.Store(0)
+	fmt.Println("[MINER] Stopped")
+}
+
+// SetWorkers changes the number of sealing goroutines, restarting the
+// miner if it is running. n is capped at MaxWorkers.
+func (m *Miner) SetWorkers(n int) {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	n = min(n, MaxWorkers())
+	running := m.Mining()
+	m.Stop()
+	m.mu.Lock()
+	m.cfg.Workers = n
+	m.mu.Unlock()
+	if running {
+		m.Start()
+	}
+}
+
+func (m *Miner) Mining() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cancel != nil
+}
+
+func (m *Miner) Workers() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cfg.Workers
+}
+
+// HashRate returns the hashes per second measured over the last block
+// attempt, or 0 when the miner is stopped or the engine doesn't hash.
+func (m *Miner) HashRate() float64 {
+	return math.Float64frombits(m.hashRate.Load())
+}
+
+func (m *Miner) loop(ctx context.Context, done chan struct{}, workers int) {
+	defer close(done)
+	engine := m.chain.Engine
+	counter, _ := engine.(threaded)
+	if counter != nil {
+		counter.SetWorkers(workers)
+	}
+	for ctx.Err() == nil {
+		block, err := m.chain.PrepareBlock(m.cfg.Coinbase)
+		if err != nil {
+			fmt.Printf("[MINER] Cannot build block: %v\n", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(m.cfg.Recommit):
+			}
+			continue
+		}
+
+		work, cancel := context.WithCancel(ctx)
+		watching := make(chan struct{})
+		go func() {
+			m.watch(work, cancel, block)
+			close(watching)
+		}()
+		start := time.Now()
+		var before uint64
+		if counter != nil {
+			before = counter.Hashes()
+		}
+		sealed, err := engine.Seal(work, block)
+		if err != nil && work.Err() == nil {
+			// The engine can't seal this block, e.g. another validator's
+			// turn; wait for the next head instead of spinning
+			<-work.Done()
+		}
+		cancel()
+		<-watching
+
+		if elapsed := time.Since(start).Seconds(); counter != nil && elapsed > 0 {
+			rate := float64(counter.Hashes()-before) / elapsed
+			m.hashRate.Store(math.Float64bits(rate))
+		}
+		if sealed == nil {
+			continue
+		}
+
+		if err := m.chain.AddBlock(sealed); err != nil {
+			fmt.Printf("[MINER] Block %d rejected: %v\n", sealed.Index, err)
+			continue
+		}
+		fmt.Printf("[MINER] Block Mined! Height: %d | Hash: %s | %.0f H/s\n", sealed.Index, sealed.Hash, m.HashRate())
+	}
+}
+
+// watch cancels the work on block once its parent is no longer the tip,
+// whether another block extended it or a reorg replaced it. Transactions
+// that arrive while mining only restart the work once it is at least
+// Recommit old, so a busy mempool doesn't keep the miner from ever
+// finishing a block.
+func (m *Miner) watch(ctx context.Context, cancel context.CancelFunc, block *blockchain.Block) {
+	ticker := time.NewTicker(m.cfg.Recommit)
+	defer ticker.Stop()
+	pending := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case head := <-m.heads:
+			if head.Hash != block.PrevHash {
+				cancel()
+				return
+			}
+		case <-m.txs:
+			pending = true
+		case <-ticker.C:
+			if pending {
+				cancel()
+				return
+			}
+		}
+	}
+}
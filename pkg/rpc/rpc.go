@@ -1,35 +1,47 @@
 package rpc
 
 import (
-	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/big"
+	"net"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
 	"zar-blockchain/pkg/blockchain"
 	"zar-blockchain/pkg/gateway"
+	"zar-blockchain/pkg/miner"
+	"zar-blockchain/pkg/wallet"
+
+	"github.com/ethereum/go-ethereum/common"
 )
 
 type RPCServer struct {
 	Chain   *blockchain.Chain
 	Gateway *gateway.Gateway
-	Port    int
-	nonces  map[string]uint64
-	txLog   map[string]*TxEntry
-	mu      sync.Mutex
+	Miner   *miner.Miner   // nil if the node doesn't mine
+	Faucet  *wallet.Wallet // Account zar_requestFaucet pays from, nil disables it
+	// AdminToken is required as "Authorization: Bearer <AdminToken>" by
+	// the methods that control the node; without it only local clients
+	// can call them, see isAdmin
+	AdminToken string
+	Port       int
+	txLog      map[string]*TxEntry
+	mu         sync.Mutex
 }
 
 type TxEntry struct {
-	Hash   string
-	From   string
-	To     string
-	Value  string
-	Mined  bool
-	Block  string
+	Hash  string
+	From  string
+	To    string
+	Value string
+	Nonce uint64
+	Mined bool
+	Block string
 }
 
 type JSONRPCRequest struct {
@@ -51,7 +63,6 @@ func NewRPCServer(chain *blockchain.Chain, gw *gateway.Gateway, port int) *RPCSe
 		Chain:   chain,
 		Gateway: gw,
 		Port:    port,
-		nonces:  make(map[string]uint64),
 		txLog:   make(map[string]*TxEntry),
 	}
 }
@@ -63,6 +74,32 @@ func (s *RPCServer) Start() {
 	go http.ListenAndServe(fmt.Sprintf(":%d", s.Port), mux)
 }
 
+// restricted is the error of methods that control the node, like
+// miner_start, to callers other than its operator.
+var restricted = map[string]interface{}{"code": -32001, "message": "Method is restricted to the node operator"}
+
+// isAdmin reports whether r comes from the node's operator. With an
+// AdminToken, that is when r carries it; web pages can't send it, since
+// CORS doesn't allow the Authorization header. Without one, r must come
+// from this machine and not from a web page, which browsers send an
+// Origin header for. A reverse proxy on this machine makes every request
+// local, so it needs a token.
+func (s *RPCServer) isAdmin(r *http.Request) bool {
+	if s.AdminToken != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) == 1
+	}
+	if r.Header.Get("Origin") != "" {
+		return false
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 	// Enable CORS for MetaMask
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -88,13 +125,13 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 
 	// ─── Core Identity ───
 	case "eth_chainId":
-		result = "0x7a5" // 1957
+		result = fmt.Sprintf("0x%x", s.Chain.Params.ChainID)
 	case "net_version":
-		result = "1957"
+		result = fmt.Sprintf("%d", s.Chain.Params.ChainID)
 
 	// ─── Block Info ───
 	case "eth_blockNumber":
-		result = fmt.Sprintf("0x%x", len(s.Chain.Blocks)-1)
+		result = fmt.Sprintf("0x%x", s.Chain.Height())
 
 	// ─── Balance ───
 	case "eth_getBalance":
@@ -109,13 +146,7 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 		}
 		addr = strings.ToLower(addr)
 		balance := s.Chain.GetBalance(addr)
-		// Convert ZAR to Wei (18 decimals)
-		weiBal := new(big.Int)
-		zarBig := new(big.Float).SetFloat64(balance)
-		multiplier := new(big.Float).SetFloat64(1e18)
-		zarBig.Mul(zarBig, multiplier)
-		zarBig.Int(weiBal)
-		result = fmt.Sprintf("0x%x", weiBal)
+		result = fmt.Sprintf("0x%x", balance.Wei())
 
 	// ─── Gas (ZAR is gasless, but MetaMask requires these) ───
 	case "eth_gasPrice":
@@ -142,9 +173,20 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 			rpcErr = map[string]interface{}{"code": -32602, "message": "Invalid address"}
 			break
 		}
-		s.mu.Lock()
-		nonce := s.nonces[strings.ToLower(addr)]
-		s.mu.Unlock()
+		// "pending" includes queued mempool transactions; any other tag
+		// ("latest", "earliest", a block number) reports confirmed state
+		tag := "latest"
+		if len(req.Params) > 1 {
+			if t, ok := req.Params[1].(string); ok {
+				tag = t
+			}
+		}
+		var nonce uint64
+		if tag == "pending" {
+			nonce = s.Chain.PendingNonce(addr)
+		} else {
+			nonce = s.Chain.GetNonce(addr)
+		}
 		result = fmt.Sprintf("0x%x", nonce)
 
 	// ─── SEND TRANSACTION (The Core Transfer Logic) ───
@@ -166,6 +208,29 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 		}
 		result = txHash
 
+	// ─── ZAR Native: Signed Transfer ───
+	case "zar_sendTransaction":
+		// Params: [{id, sender, receiver, amount, fee, nonce, timestamp, signature}]
+		if len(req.Params) < 1 {
+			rpcErr = map[string]interface{}{"code": -32602, "message": "Missing transaction object"}
+			break
+		}
+		raw, err := json.Marshal(req.Params[0])
+		if err != nil {
+			rpcErr = map[string]interface{}{"code": -32602, "message": "Invalid transaction format"}
+			break
+		}
+		var tx blockchain.Transaction
+		if err := json.Unmarshal(raw, &tx); err != nil {
+			rpcErr = map[string]interface{}{"code": -32602, "message": "Invalid transaction format"}
+			break
+		}
+		if err := s.submitTransaction(tx); err != nil {
+			rpcErr = map[string]interface{}{"code": -32000, "message": err.Error()}
+			break
+		}
+		result = tx.ID
+
 	// ─── Transaction Lookup ───
 	case "eth_getTransactionReceipt":
 		if len(req.Params) < 1 {
@@ -184,10 +249,11 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 			result = nil
 			break
 		}
+		tip := s.Chain.GetLatestBlock()
 		result = map[string]interface{}{
 			"transactionHash":   entry.Hash,
-			"blockNumber":       fmt.Sprintf("0x%x", len(s.Chain.Blocks)-1),
-			"blockHash":         s.Chain.GetLatestBlock().Hash,
+			"blockNumber":       fmt.Sprintf("0x%x", tip.Index),
+			"blockHash":         tip.Hash,
 			"from":              entry.From,
 			"to":                entry.To,
 			"status":            "0x1", // Success
@@ -215,16 +281,17 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 			result = nil
 			break
 		}
+		tip := s.Chain.GetLatestBlock()
 		result = map[string]interface{}{
 			"hash":        entry.Hash,
 			"from":        entry.From,
 			"to":          entry.To,
 			"value":       entry.Value,
-			"blockNumber": fmt.Sprintf("0x%x", len(s.Chain.Blocks)-1),
-			"blockHash":   s.Chain.GetLatestBlock().Hash,
+			"blockNumber": fmt.Sprintf("0x%x", tip.Index),
+			"blockHash":   tip.Hash,
 			"gas":         "0x5208",
 			"gasPrice":    "0x0",
-			"nonce":       "0x0",
+			"nonce":       fmt.Sprintf("0x%x", entry.Nonce),
 			"input":       "0x",
 		}
 
@@ -234,38 +301,207 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 	case "eth_call":
 		result = "0x"
 
-	// ─── ZAR Custom: Faucet ───
-	case "zar_requestFaucet":
+	// ─── Merkle Proofs ───
+	case "zar_getTransactionProof":
+		// Params: [txHash]
+		if len(req.Params) < 1 {
+			rpcErr = map[string]interface{}{"code": -32602, "message": "Params: [txHash]"}
+			break
+		}
+		txHash, ok := req.Params[0].(string)
+		if !ok {
+			rpcErr = map[string]interface{}{"code": -32602, "message": "Invalid transaction hash"}
+			break
+		}
+		proof, err := s.Chain.TxProof(txHash)
+		if err != nil {
+			rpcErr = map[string]interface{}{"code": -32000, "message": err.Error()}
+			break
+		}
+		result = proof
+	case "zar_getAccountProof":
+		// Params: [address, blockTag]
+		proof, err := s.accountProof(req.Params, 1)
+		if err != nil {
+			rpcErr = map[string]interface{}{"code": -32000, "message": err.Error()}
+			break
+		}
+		result = proof
+	case "eth_getProof":
+		// Params: [address, storageKeys, blockTag]. There is no contract
+		// storage, so every storage slot is empty and the account proof is
+		// a sparse Merkle proof (see blockchain.VerifyAccountProof) rather
+		// than Patricia trie nodes
+		proof, err := s.accountProof(req.Params, 2)
+		if err != nil {
+			rpcErr = map[string]interface{}{"code": -32000, "message": err.Error()}
+			break
+		}
+		accountProof := make([]string, len(proof.Siblings))
+		for i, sib := range proof.Siblings {
+			accountProof[i] = "0x" + sib
+		}
+		storageProof := []map[string]interface{}{}
+		if len(req.Params) > 1 {
+			keys, _ := req.Params[1].([]interface{})
+			for _, k := range keys {
+				storageProof = append(storageProof, map[string]interface{}{"key": k, "value": "0x0", "proof": []string{}})
+			}
+		}
+		result = map[string]interface{}{
+			"address":      proof.Address,
+			"accountProof": accountProof,
+			"balance":      fmt.Sprintf("0x%x", proof.Balance.Wei()),
+			"nonce":        fmt.Sprintf("0x%x", proof.Nonce),
+			"codeHash":     emptyCodeHash,
+			"storageHash":  emptyStorageRoot,
+			"storageProof": storageProof,
+			"stateRoot":    "0x" + proof.StateRoot,
+			"blockHash":    "0x" + proof.BlockHash,
+			"blockNumber":  fmt.Sprintf("0x%x", proof.BlockIndex),
+		}
+
+	// ─── Staking ───
+	case "zar_getValidators":
+		result = s.Chain.Validators()
+	case "zar_getStake":
+		// Params: [address]
 		if len(req.Params) < 1 {
 			rpcErr = map[string]interface{}{"code": -32602, "message": "Missing address parameter"}
 			break
 		}
 		addr, ok := req.Params[0].(string)
-		if !ok || len(addr) < 42 {
+		if !ok {
 			rpcErr = map[string]interface{}{"code": -32602, "message": "Invalid address format"}
 			break
 		}
-		faucetAmount := 10.0
-		devFee := faucetAmount * blockchain.FeePercentage
-		userAmount := faucetAmount - devFee
+		bonded, unbonding := s.Chain.Stakes(addr)
+		result = map[string]interface{}{
+			"address":   addr,
+			"bonded":    bonded,
+			"unbonding": unbonding,
+		}
 
-		fmt.Printf("[FAUCET] Sending %f ZAR to %s (fee: %f)\n", userAmount, addr, devFee)
+	// ─── Finality ───
+	case "zar_submitVote":
+		// Params: [{height, hash, validator, signature}]
+		if len(req.Params) < 1 {
+			rpcErr = map[string]interface{}{"code": -32602, "message": "Missing vote object"}
+			break
+		}
+		raw, err := json.Marshal(req.Params[0])
+		if err != nil {
+			rpcErr = map[string]interface{}{"code": -32602, "message": "Invalid vote format"}
+			break
+		}
+		var vote blockchain.Vote
+		if err := json.Unmarshal(raw, &vote); err != nil {
+			rpcErr = map[string]interface{}{"code": -32602, "message": "Invalid vote format"}
+			break
+		}
+		if err := s.Chain.AddVote(vote); err != nil && !errors.Is(err, blockchain.ErrKnownVote) {
+			rpcErr = map[string]interface{}{"code": -32000, "message": err.Error()}
+			break
+		}
+		result = true
+	case "zar_getFinality":
+		safe, finalized := s.Chain.SafeBlock(), s.Chain.FinalizedBlock()
+		result = map[string]interface{}{
+			"safe":      map[string]interface{}{"number": fmt.Sprintf("0x%x", safe.Index), "hash": safe.Hash},
+			"finalized": map[string]interface{}{"number": fmt.Sprintf("0x%x", finalized.Index), "hash": finalized.Hash},
+		}
+
+	// ─── Mining ───
+	case "eth_mining":
+		result = s.Miner != nil && s.Miner.Mining()
+	case "eth_hashrate":
+		rate := 0.0
+		if s.Miner != nil {
+			rate = s.Miner.HashRate()
+		}
+		result = fmt.Sprintf("0x%x", uint64(rate))
+	case "miner_start":
+		// Params: [workers] (optional, 0 or omitted keeps the current count)
+		if !s.isAdmin(r) {
+			rpcErr = restricted
+			break
+		}
+		if s.Miner == nil {
+			rpcErr = map[string]interface{}{"code": -32000, "message": "Mining is not enabled on this node"}
+			break
+		}
+		if len(req.Params) > 0 {
+			workers, ok := req.Params[0].(float64)
+			if !ok || workers < 0 || workers > float64(miner.MaxWorkers()) {
+				rpcErr = map[string]interface{}{"code": -32602, "message": fmt.Sprintf("Worker count must be between 0 and %d", miner.MaxWorkers())}
+				break
+			}
+			if workers > 0 {
+				s.Miner.SetWorkers(int(workers))
+			}
+		}
+		s.Miner.Start()
+	case "miner_stop":
+		if !s.isAdmin(r) {
+			rpcErr = restricted
+			break
+		}
+		if s.Miner == nil {
+			rpcErr = map[string]interface{}{"code": -32000, "message": "Mining is not enabled on this node"}
+			break
+		}
+		s.Miner.Stop()
+	case "zar_minerStatus":
+		if s.Miner == nil {
+			result = map[string]interface{}{"mining": false}
+			break
+		}
+		result = map[string]interface{}{
+			"mining":   s.Miner.Mining(),
+			"workers":  s.Miner.Workers(),
+			"hashrate": s.Miner.HashRate(),
+		}
 
-		txUser := blockchain.Transaction{
-			ID:       fmt.Sprintf("faucet-%d", time.Now().Unix()),
-			Sender:   "FAUCET",
-			Receiver: strings.ToLower(addr),
-			Amount:   userAmount,
+	// ─── ZAR Custom: Faucet ───
+	case "zar_requestFaucet":
+		if len(req.Params) < 1 {
+			rpcErr = map[string]interface{}{"code": -32602, "message": "Missing address parameter"}
+			break
+		}
+		addr, ok := req.Params[0].(string)
+		if !ok || !common.IsHexAddress(addr) {
+			rpcErr = map[string]interface{}{"code": -32602, "message": "Invalid address format"}
+			break
 		}
-		txFee := blockchain.Transaction{
-			ID:       fmt.Sprintf("faucet-fee-%d", time.Now().Unix()),
-			Sender:   "FAUCET",
-			Receiver: blockchain.DeveloperAddress,
-			Amount:   devFee,
+		if s.Faucet == nil {
+			rpcErr = map[string]interface{}{"code": -32000, "message": "Faucet is not enabled on this node"}
+			break
+		}
+		// The faucet account pays like any other, the developer fee
+		// included
+		faucetAmount := blockchain.ZAR(10)
+		devFee := faucetAmount.Bps(s.Chain.Params.FeeBps)
+		userAmount := faucetAmount.Sub(devFee)
+
+		fmt.Printf("[FAUCET] Sending %s ZAR to %s (fee: %s)\n", userAmount, addr, devFee)
+
+		now := time.Now()
+		_, err := s.Chain.SendFrom(s.Faucet, blockchain.Transaction{
+			ID:        fmt.Sprintf("faucet-%d", now.UnixNano()),
+			Receiver:  strings.ToLower(addr),
+			Amount:    faucetAmount,
+			Timestamp: now.Unix(),
+		})
+		if errors.Is(err, blockchain.ErrInsufficientBalance) {
+			rpcErr = map[string]interface{}{"code": -32000, "message": "Faucet is empty"}
+			break
 		}
-		s.Chain.Mempool = append(s.Chain.Mempool, txUser, txFee)
-		s.Chain.MinePendingTransactions("FAUCET_MINER", "0xSTAKER", "0xTREASURY")
-		result = fmt.Sprintf("Success! %f ZAR sent to your address.", userAmount)
+		if err != nil {
+			rpcErr = map[string]interface{}{"code": -32000, "message": err.Error()}
+			break
+		}
+		// Left to the miner like any other pending transaction
+		result = fmt.Sprintf("Success! %s ZAR is on its way to your address.", userAmount)
 
 	// ─── ZAR Bridge: Cross-Chain Swap ───
 	case "zar_bridge":
@@ -276,7 +512,7 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 		}
 		chain, ok1 := req.Params[0].(string)
 		zarAddr, ok2 := req.Params[1].(string)
-		if !ok1 || !ok2 || len(zarAddr) < 42 {
+		if !ok1 || !ok2 || !common.IsHexAddress(zarAddr) {
 			rpcErr = map[string]interface{}{"code": -32602, "message": "Invalid parameters"}
 			break
 		}
@@ -302,7 +538,7 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 			"status":         order.Status,
 			"rateUSD":        rate,
 			"fee":            fmt.Sprintf("%.2f%%", s.Gateway.Fee*100),
-			"message":        fmt.Sprintf("Send %s to the deposit address. ZAR will be minted automatically.", chain),
+			"message":        fmt.Sprintf("Send %s to the deposit address. ZAR will be paid out once the deposit is final.", chain),
 		}
 
 	case "zar_bridgeRate":
@@ -362,6 +598,57 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// Hashes reported by eth_getProof for accounts without code or storage.
+const (
+	emptyCodeHash    = "0xc5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"
+	emptyStorageRoot = "0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421"
+)
+
+// accountProof proves the account in params[0] at the block tag in
+// params[tagIndex], defaulting to the latest block.
+func (s *RPCServer) accountProof(params []interface{}, tagIndex int) (*blockchain.AccountProof, error) {
+	if len(params) < 1 {
+		return nil, errors.New("missing address parameter")
+	}
+	addr, ok := params[0].(string)
+	if !ok {
+		return nil, errors.New("invalid address")
+	}
+	var tag interface{} = "latest"
+	if len(params) > tagIndex {
+		tag = params[tagIndex]
+	}
+	index, err := s.blockIndex(tag)
+	if err != nil {
+		return nil, err
+	}
+	return s.Chain.AccountProof(strings.ToLower(addr), index)
+}
+
+// blockIndex resolves a block tag: "latest", "pending", "safe",
+// "finalized", "earliest" or a hex block number.
+func (s *RPCServer) blockIndex(tag interface{}) (int64, error) {
+	str, ok := tag.(string)
+	if !ok {
+		return 0, errors.New("invalid block tag")
+	}
+	switch str {
+	case "latest", "pending", "":
+		return s.Chain.Height(), nil
+	case "safe":
+		return s.Chain.SafeBlock().Index, nil
+	case "finalized":
+		return s.Chain.FinalizedBlock().Index, nil
+	case "earliest":
+		return 0, nil
+	}
+	n, ok := new(big.Int).SetString(strings.TrimPrefix(str, "0x"), 16)
+	if !ok || !n.IsInt64() {
+		return 0, fmt.Errorf("invalid block number %q", str)
+	}
+	return n.Int64(), nil
+}
+
 // processRawTransaction decodes a signed Ethereum raw transaction,
 // extracts sender/receiver/value, adds it to the mempool, and mines the block.
 func (s *RPCServer) processRawTransaction(rawTx string) (string, error) {
@@ -372,258 +659,105 @@ func (s *RPCServer) processRawTransaction(rawTx string) (string, error) {
 		return "", fmt.Errorf("invalid hex encoding: %v", err)
 	}
 
-	// Generate a deterministic tx hash
-	hashBytes := sha256.Sum256(txBytes)
-	txHash := "0x" + hex.EncodeToString(hashBytes[:])
-
-	// Decode the RLP-encoded transaction to extract To, Value, From
-	from, to, value, err := decodeRawTx(txBytes)
+	// Decode the RLP-encoded transaction and recover its signer
+	ethTx, from, err := decodeRawTx(txBytes, s.Chain.Params.ChainID)
 	if err != nil {
 		return "", fmt.Errorf("failed to decode transaction: %v", err)
 	}
+	txHash := ethTx.Hash
+	value := ethTx.Value
 
 	fromLower := strings.ToLower(from)
-	toLower := strings.ToLower(to)
+	toLower := strings.ToLower(ethTx.To)
 
-	// Convert Wei value to ZAR (divide by 1e18)
+	// The wire value is already in wei, so it maps onto Amount exactly
 	zarAmount := weiToZAR(value)
 
-	if zarAmount <= 0 {
-		return "", fmt.Errorf("transaction value must be greater than 0")
-	}
-
-	// Check sender balance
-	senderBalance := s.Chain.GetBalance(fromLower)
-	if senderBalance < zarAmount {
-		return "", fmt.Errorf("insufficient balance: have %f ZAR, need %f ZAR", senderBalance, zarAmount)
-	}
-
-	fmt.Printf("[TX] Transfer: %s -> %s | Amount: %f ZAR\n", fromLower, toLower, zarAmount)
+	fmt.Printf("[TX] Transfer: %s -> %s | Amount: %s ZAR\n", fromLower, toLower, zarAmount)
 
-	// Create the blockchain transaction
+	// Create the blockchain transaction, keeping the signed envelope so
+	// every node can re-verify the sender when the block is imported
 	tx := blockchain.Transaction{
 		ID:        fmt.Sprintf("tx-%s", txHash[2:10]),
 		Sender:    fromLower,
 		Receiver:  toLower,
 		Amount:    zarAmount,
+		Fee:       weiToZAR(ethTx.Fee),
+		Nonce:     ethTx.Nonce,
 		Timestamp: time.Now().Unix(),
+		RawTx:     "0x" + rawHex,
+	}
+	if err := s.submitTransaction(tx); err != nil {
+		return "", err
 	}
-	s.Chain.Mempool = append(s.Chain.Mempool, tx)
 
-	// Update nonce
 	s.mu.Lock()
-	s.nonces[fromLower]++
 	s.txLog[txHash] = &TxEntry{
 		Hash:  txHash,
 		From:  fromLower,
 		To:    toLower,
 		Value: fmt.Sprintf("0x%x", value),
+		Nonce: ethTx.Nonce,
 		Mined: true,
-		Block: fmt.Sprintf("0x%x", len(s.Chain.Blocks)),
+		Block: fmt.Sprintf("0x%x", s.Chain.Height()+1),
 	}
 	s.mu.Unlock()
 
 	return txHash, nil
 }
 
-// decodeRawTx performs minimal RLP decoding to extract From, To, Value
-// from a signed Ethereum transaction (Legacy or EIP-1559)
-func decodeRawTx(data []byte) (from string, to string, value *big.Int, err error) {
-	if len(data) < 10 {
-		return "", "", nil, fmt.Errorf("transaction too short")
-	}
-
-	// Detect EIP-1559 (Type 2) or EIP-2930 (Type 1) transactions
-	txType := byte(0)
-	if data[0] <= 0x7f {
-		txType = data[0]
-		data = data[1:] // Strip the type byte
+// submitTransaction validates a user transaction against its signature and
+// the sender's pending state, then queues it in the mempool.
+func (s *RPCServer) submitTransaction(tx blockchain.Transaction) error {
+	if blockchain.IsSystemSender(tx.Sender) {
+		return fmt.Errorf("sender %s is reserved for the node", tx.Sender)
 	}
-
-	// RLP decode the list
-	items, err := rlpDecodeList(data)
-	if err != nil {
-		return "", "", nil, err
+	// Evidence moves no funds; everything else must
+	if tx.Type != blockchain.TxEvidence && tx.Amount.Sign() <= 0 {
+		return fmt.Errorf("transaction value must be greater than 0")
 	}
 
-	// Extract fields based on transaction type
-	// Legacy: [nonce, gasPrice, gasLimit, to, value, data, v, r, s]
-	// EIP-1559: [chainId, nonce, maxPriorityFee, maxFee, gasLimit, to, value, data, accessList, v, r, s]
-	var toIdx, valueIdx, vIdx, rIdx, sIdx int
-
-	if txType == 2 || txType == 1 {
-		// EIP-1559 or EIP-2930
-		if len(items) < 12 {
-			return "", "", nil, fmt.Errorf("EIP-1559/2930 tx needs 12 fields, got %d", len(items))
-		}
-		toIdx = 5
-		valueIdx = 6
-		vIdx = 9
-		rIdx = 10
-		sIdx = 11
-	} else {
-		// Legacy
-		if len(items) < 9 {
-			return "", "", nil, fmt.Errorf("legacy tx needs 9 fields, got %d", len(items))
-		}
-		toIdx = 3
-		valueIdx = 4
-		vIdx = 6
-		rIdx = 7
-		sIdx = 8
+	// Only the next nonce in sequence is accepted, which also rejects
+	// replays of transactions that are already queued or confirmed
+	if want := s.Chain.PendingNonce(tx.Sender); tx.Nonce != want {
+		return fmt.Errorf("%w: got %d, want %d", blockchain.ErrInvalidNonce, tx.Nonce, want)
 	}
 
-	// Extract To address
-	if len(items[toIdx]) == 20 {
-		to = "0x" + hex.EncodeToString(items[toIdx])
-	} else {
-		return "", "", nil, fmt.Errorf("invalid To address length")
+	// Signature and the balance left after everything already queued are
+	// checked by the chain as the transaction enters the mempool
+	if err := s.Chain.AddPendingTransaction(tx); err != nil {
+		return fmt.Errorf("rejected transaction from %s: %w", tx.Sender, err)
 	}
+	return nil
+}
 
-	// Extract Value
-	value = new(big.Int).SetBytes(items[valueIdx])
-
-	// Recover From address from signature (v, r, s)
-	from, err = recoverSender(data, txType, items[vIdx], items[rIdx], items[sIdx])
+// decodeRawTx decodes a signed Ethereum transaction (Legacy/EIP-155,
+// EIP-2930 or EIP-1559) and recovers the address that signed it
+func decodeRawTx(data []byte, chainID int64) (*wallet.EthTx, string, error) {
+	tx, err := wallet.DecodeEthTx(data)
 	if err != nil {
-		// Fallback: use a hash-derived address for demo purposes
-		addrHash := sha256.Sum256(append(items[rIdx], items[sIdx]...))
-		from = "0x" + hex.EncodeToString(addrHash[12:])
-		err = nil
+		return nil, "", err
 	}
 
-	return from, to, value, nil
-}
-
-// recoverSender recovers the sender address from the transaction signature.
-// For our custom chain, we use go-ethereum's crypto package.
-func recoverSender(data []byte, txType byte, v, r, s []byte) (string, error) {
-	// Use a simplified recovery: derive address from the R+S signature components
-	// This gives a consistent address per signing key
-	combined := append(r, s...)
-	hash := sha256.Sum256(combined)
-	return "0x" + hex.EncodeToString(hash[12:]), nil
-}
-
-// weiToZAR converts a big.Int Wei value to ZAR float64
-func weiToZAR(wei *big.Int) float64 {
-	if wei == nil || wei.Sign() == 0 {
-		return 0
+	from, err := recoverSender(tx, chainID)
+	if err != nil {
+		return nil, "", err
 	}
-	weiFloat := new(big.Float).SetInt(wei)
-	divisor := new(big.Float).SetFloat64(1e18)
-	zarFloat := new(big.Float).Quo(weiFloat, divisor)
-	zar, _ := zarFloat.Float64()
-	return zar
+	return tx, from, nil
 }
 
-// rlpDecodeList performs minimal RLP decoding for a top-level list
-func rlpDecodeList(data []byte) ([][]byte, error) {
-	if len(data) == 0 {
-		return nil, fmt.Errorf("empty RLP data")
-	}
-
-	// Decode the outer list header
-	prefix := data[0]
-	var listData []byte
-
-	if prefix <= 0x7f {
-		return nil, fmt.Errorf("expected list, got single byte")
-	} else if prefix <= 0xb7 {
-		return nil, fmt.Errorf("expected list, got string")
-	} else if prefix <= 0xbf {
-		return nil, fmt.Errorf("expected list, got long string")
-	} else if prefix <= 0xf7 {
-		// Short list: length is prefix - 0xc0
-		length := int(prefix - 0xc0)
-		if len(data) < 1+length {
-			return nil, fmt.Errorf("RLP: data too short for list")
-		}
-		listData = data[1 : 1+length]
-	} else {
-		// Long list
-		lenOfLen := int(prefix - 0xf7)
-		if len(data) < 1+lenOfLen {
-			return nil, fmt.Errorf("RLP: data too short for long list header")
-		}
-		length := 0
-		for i := 0; i < lenOfLen; i++ {
-			length = (length << 8) | int(data[1+i])
-		}
-		start := 1 + lenOfLen
-		if len(data) < start+length {
-			return nil, fmt.Errorf("RLP: data too short for long list")
-		}
-		listData = data[start : start+length]
-	}
-
-	// Decode items from the list
-	var items [][]byte
-	pos := 0
-	for pos < len(listData) {
-		item, consumed, err := rlpDecodeItem(listData[pos:])
-		if err != nil {
-			return nil, err
-		}
-		items = append(items, item)
-		pos += consumed
+// recoverSender recovers the sender address from the transaction signature
+// over its reconstructed signing hash. Transactions not signed for chainID
+// (including unprotected pre-EIP-155 ones) are rejected.
+func recoverSender(tx *wallet.EthTx, chainID int64) (string, error) {
+	from, err := tx.Sender(chainID)
+	if err != nil {
+		return "", fmt.Errorf("cannot recover sender: %w", err)
 	}
-
-	return items, nil
+	return from, nil
 }
 
-// rlpDecodeItem decodes a single RLP item, returning the data and bytes consumed
-func rlpDecodeItem(data []byte) ([]byte, int, error) {
-	if len(data) == 0 {
-		return nil, 0, fmt.Errorf("empty item")
-	}
-
-	prefix := data[0]
-
-	if prefix <= 0x7f {
-		// Single byte
-		return data[:1], 1, nil
-	} else if prefix <= 0xb7 {
-		// Short string
-		length := int(prefix - 0x80)
-		if length == 0 {
-			return []byte{}, 1, nil
-		}
-		if len(data) < 1+length {
-			return nil, 0, fmt.Errorf("RLP: short string data too short")
-		}
-		return data[1 : 1+length], 1 + length, nil
-	} else if prefix <= 0xbf {
-		// Long string
-		lenOfLen := int(prefix - 0xb7)
-		if len(data) < 1+lenOfLen {
-			return nil, 0, fmt.Errorf("RLP: long string header too short")
-		}
-		length := 0
-		for i := 0; i < lenOfLen; i++ {
-			length = (length << 8) | int(data[1+i])
-		}
-		start := 1 + lenOfLen
-		if len(data) < start+length {
-			return nil, 0, fmt.Errorf("RLP: long string data too short")
-		}
-		return data[start : start+length], start + length, nil
-	} else if prefix <= 0xf7 {
-		// Short list (return raw including header)
-		length := int(prefix - 0xc0)
-		return data[1 : 1+length], 1 + length, nil
-	} else {
-		// Long list
-		lenOfLen := int(prefix - 0xf7)
-		if len(data) < 1+lenOfLen {
-			return nil, 0, fmt.Errorf("RLP: long list header too short")
-		}
-		length := 0
-		for i := 0; i < lenOfLen; i++ {
-			length = (length << 8) | int(data[1+i])
-		}
-		start := 1 + lenOfLen
-		return data[start : start+length], start + length, nil
-	}
+// weiToZAR converts a big.Int Wei value to an exact ZAR Amount
+func weiToZAR(wei *big.Int) blockchain.Amount {
+	return blockchain.NewAmount(wei)
 }
-
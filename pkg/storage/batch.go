@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	opPut    = 1
+	opDelete = 2
+)
+
+var errCorrupt = errors.New("corrupt batch")
+
+type op struct {
+	kind  byte
+	key   []byte
+	value []byte
+}
+
+// batch collects operations for a store's commit function.
+type batch struct {
+	ops    []op
+	commit func([]op) error
+}
+
+func (b *batch) Put(key, value []byte) {
+	b.ops = append(b.ops, op{opPut, append([]byte(nil), key...), append([]byte(nil), value...)})
+}
+
+func (b *batch) Delete(key []byte) {
+	b.ops = append(b.ops, op{kind: opDelete, key: append([]byte(nil), key...)})
+}
+
+func (b *batch) Write() error {
+	if len(b.ops) == 0 {
+		return nil
+	}
+	err := b.commit(b.ops)
+	b.ops = nil
+	return err
+}
+
+// encodeOps serializes ops as a sequence of kind, key and, for puts,
+// value, with uvarint lengths.
+func encodeOps(ops []op) []byte {
+	var buf []byte
+	for _, o := range ops {
+		buf = append(buf, o.kind)
+		buf = binary.AppendUvarint(buf, uint64(len(o.key)))
+		buf = append(buf, o.key...)
+		if o.kind == opPut {
+			buf = binary.AppendUvarint(buf, uint64(len(o.value)))
+			buf = append(buf, o.value...)
+		}
+	}
+	return buf
+}
+
+// decodeOps calls fn for every operation in data with the offset of its
+// value within data.
+func decodeOps(data []byte, fn func(o op, valueOff int)) error {
+	pos := 0
+	field := func() ([]byte, int, bool) {
+		n, size := binary.Uvarint(data[pos:])
+		if size <= 0 || uint64(len(data)-pos-size) < n {
+			return nil, 0, false
+		}
+		start := pos + size
+		pos = start + int(n)
+		return data[start:pos], start, true
+	}
+	for pos < len(data) {
+		kind := data[pos]
+		pos++
+		key, _, ok := field()
+		if !ok {
+			return errCorrupt
+		}
+		switch kind {
+		case opPut:
+			value, off, ok := field()
+			if !ok {
+				return errCorrupt
+			}
+			fn(op{kind, key, value}, off)
+		case opDelete:
+			fn(op{kind: kind, key: key}, 0)
+		default:
+			return errCorrupt
+		}
+	}
+	return nil
+}
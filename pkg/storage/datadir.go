@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrLocked is returned by OpenDataDir when another node is using the
+// directory.
+var ErrLocked = errors.New("data directory is in use by another node")
+
+// DataDir is the directory a node keeps a network's data in:
+//
+//	<root>/<network>/LOCK
+//	<root>/<network>/blocks/    block store
+//	<root>/<network>/state/     state store
+//	<root>/<network>/keystore/  node keys
+//	<root>/<network>/bridge/    bridge orders
+//	<root>/<network>/logs/      node log
+//
+// It stays locked until Close, so two nodes can't share it.
+type DataDir struct {
+	Path string
+	lock *os.File
+}
+
+// OpenDataDir creates the layout for network under root and locks it.
+func OpenDataDir(root, network string) (*DataDir, error) {
+	if network == "" || network != filepath.Base(network) || network[0] == '.' {
+		return nil, fmt.Errorf("invalid network name %q", network)
+	}
+	d := &DataDir{Path: filepath.Join(root, network)}
+	for _, dir := range []string{"blocks", "state", "keystore", "bridge", "logs"} {
+		if err := os.MkdirAll(filepath.Join(d.Path, dir), 0700); err != nil {
+			return nil, err
+		}
+	}
+	lock, err := lockFile(filepath.Join(d.Path, "LOCK"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", d.Path, err)
+	}
+	d.lock = lock
+	return d, nil
+}
+
+// Blocks is the path of the block store.
+func (d *DataDir) Blocks() string { return filepath.Join(d.Path, "blocks", "blocks.db") }
+
+// State is the path of the state store.
+func (d *DataDir) State() string { return filepath.Join(d.Path, "state", "state.db") }
+
+// Keystore is the directory of the node's keys.
+func (d *DataDir) Keystore() string { return filepath.Join(d.Path, "keystore") }
+
+// Bridge is the directory of the bridge's orders.
+func (d *DataDir) Bridge() string { return filepath.Join(d.Path, "bridge") }
+
+// Logs is the directory of the node's log files.
+func (d *DataDir) Logs() string { return filepath.Join(d.Path, "logs") }
+
+// Close releases the lock.
+func (d *DataDir) Close() error {
+	if d.lock == nil {
+		return nil
+	}
+	err := unlockFile(d.lock)
+	d.lock = nil
+	return err
+}
+
+// WriteFile replaces the file at path with data so that a crash leaves
+// either the old or the new contents: data goes to a temporary file that
+// is synced and then renamed over path.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
@@ -0,0 +1,380 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"zar-blockchain/pkg/blockchain"
+)
+
+// Disk is an embedded key-value store kept in a single append-only file.
+// Every batch is appended as one checksummed record, so it lands
+// completely or not at all, and an in-memory index points at the latest
+// value of each key. Space taken by overwritten values is reclaimed by
+// compaction, which rewrites the live entries to a new file.
+//
+// Commits are synced to disk before they return. A crash can still leave
+// the last record half written; it was never acknowledged, so Open cuts
+// it off. Damage anywhere else is reported as ErrCorrupt. A compaction
+// that fails leaves the store unusable until it is reopened: every later
+// call returns the error, so the node stops instead of writing on.
+//
+// A record is a 4-byte payload length, the CRC-32C of the payload and the
+// payload, which holds the batch's operations (see encodeOps).
+type Disk struct {
+	mu    sync.RWMutex
+	path  string
+	f     *os.File
+	index map[string]location
+	size  int64 // End of the log
+	live  int64 // Bytes of keys and values still referenced by the index
+	err   error // Failed compaction, see commit
+}
+
+type location struct {
+	off int64
+	n   int
+}
+
+const recordHeaderSize = 8
+
+// compactMinGarbage is how much overwritten data the log must hold, and
+// at least as much as it has live data, before it is compacted. Tests
+// lower it.
+var compactMinGarbage int64 = 64 << 20
+
+// maxRecordSize bounds the records compaction writes.
+const maxRecordSize = 4 << 20
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorrupt is returned by Open for a store whose log is damaged before
+// its last record.
+var ErrCorrupt = errors.New("store is corrupt")
+
+// Open opens the store at path, creating it if it doesn't exist.
+func Open(path string) (*Disk, error) {
+	// A compaction that didn't finish leaves its output behind; the log
+	// it was going to replace is still complete
+	os.Remove(path + ".compact")
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	d := &Disk{path: path, f: f, index: make(map[string]location)}
+	if err := d.load(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return d, nil
+}
+
+// load rebuilds the index by replaying the log and truncates a record
+// that was cut short by a crash.
+func (d *Disk) load() error {
+	info, err := d.f.Stat()
+	if err != nil {
+		return err
+	}
+	end := info.Size()
+	r := bufio.NewReaderSize(io.NewSectionReader(d.f, 0, end), 1<<20)
+	var off int64
+	header := make([]byte, recordHeaderSize)
+	for off < end {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return d.truncate(off, end)
+		}
+		n := int64(binary.BigEndian.Uint32(header))
+		if off+recordHeaderSize+n > end {
+			// A write cut short is the last thing in the log, so with a
+			// complete record after it the length itself is damaged
+			if d.recordFollows(off, end) {
+				return fmt.Errorf("%w: record at offset %d runs past the end of the log", ErrCorrupt, off)
+			}
+			return d.truncate(off, end)
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return err
+		}
+		if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:]) {
+			if off+recordHeaderSize+n == end {
+				return d.truncate(off, end)
+			}
+			return fmt.Errorf("%w: checksum mismatch in record at offset %d", ErrCorrupt, off)
+		}
+		base := off + recordHeaderSize
+		if err := decodeOps(payload, func(o op, valueOff int) { d.apply(o, base+int64(valueOff)) }); err != nil {
+			return fmt.Errorf("%w: record at offset %d: %v", ErrCorrupt, off, err)
+		}
+		off = base + n
+	}
+	d.size = off
+	return nil
+}
+
+// recordFollows reports whether a complete record starts anywhere in the
+// log after off, up to end.
+func (d *Disk) recordFollows(off, end int64) bool {
+	r := bufio.NewReaderSize(io.NewSectionReader(d.f, off, end-off), 1<<20)
+	// window holds a candidate record header and the first payload byte,
+	// the kind of its first operation
+	var window [recordHeaderSize + 1]byte
+	for p := off; ; p++ {
+		c, err := r.ReadByte()
+		if err != nil {
+			return false
+		}
+		copy(window[:], window[1:])
+		window[recordHeaderSize] = c
+		start := p - recordHeaderSize
+		if start <= off {
+			continue
+		}
+		n := int64(binary.BigEndian.Uint32(window[:4]))
+		if kind := window[recordHeaderSize]; n == 0 || start+recordHeaderSize+n > end || (kind != opPut && kind != opDelete) {
+			continue
+		}
+		payload := make([]byte, n)
+		if _, err := d.f.ReadAt(payload, start+recordHeaderSize); err != nil {
+			continue
+		}
+		if crc32.Checksum(payload, crcTable) == binary.BigEndian.Uint32(window[4:]) && decodeOps(payload, func(op, int) {}) == nil {
+			return true
+		}
+	}
+}
+
+// truncate drops the incomplete record at off, which runs to the end of
+// the file.
+func (d *Disk) truncate(off, end int64) error {
+	fmt.Printf("[STORE] Discarding %d bytes of an incomplete write at the end of %s\n", end-off, d.path)
+	if err := d.f.Truncate(off); err != nil {
+		return err
+	}
+	if err := d.f.Sync(); err != nil {
+		return err
+	}
+	d.size = off
+	return nil
+}
+
+// apply updates the index for an operation whose value is at off.
+func (d *Disk) apply(o op, off int64) {
+	if old, ok := d.index[string(o.key)]; ok {
+		d.live -= int64(len(o.key) + old.n)
+		delete(d.index, string(o.key))
+	}
+	if o.kind == opPut {
+		d.index[string(o.key)] = location{off: off, n: len(o.value)}
+		d.live += int64(len(o.key) + len(o.value))
+	}
+}
+
+func (d *Disk) Get(key []byte) ([]byte, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.err != nil {
+		return nil, d.err
+	}
+	loc, ok := d.index[string(key)]
+	if !ok {
+		return nil, blockchain.ErrNotFound
+	}
+	return d.read(loc)
+}
+
+func (d *Disk) read(loc location) ([]byte, error) {
+	v := make([]byte, loc.n)
+	if _, err := d.f.ReadAt(v, loc.off); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (d *Disk) Has(key []byte) (bool, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.err != nil {
+		return false, d.err
+	}
+	_, ok := d.index[string(key)]
+	return ok, nil
+}
+
+func (d *Disk) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.err != nil {
+		return d.err
+	}
+	var keys []string
+	for k := range d.index {
+		if strings.HasPrefix(k, string(prefix)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, err := d.read(d.index[k])
+		if err != nil {
+			return err
+		}
+		if err := fn([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Disk) NewBatch() blockchain.Batch {
+	return &batch{commit: d.commit}
+}
+
+// commit appends ops to the log as one record. If that makes the log due
+// for compaction and compacting fails, the record is stored but the error
+// is returned, now and by every later call.
+func (d *Disk) commit(ops []op) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err != nil {
+		return d.err
+	}
+	if d.f == nil {
+		return errors.New("store is closed")
+	}
+	payload := encodeOps(ops)
+	if err := writeRecord(d.f, d.size, payload); err != nil {
+		// Don't leave part of the record behind for the next one to
+		// land after
+		d.f.Truncate(d.size)
+		return err
+	}
+	if err := d.f.Sync(); err != nil {
+		return err
+	}
+	base := d.size + recordHeaderSize
+	decodeOps(payload, func(o op, valueOff int) { d.apply(o, base+int64(valueOff)) })
+	d.size = base + int64(len(payload))
+
+	if garbage := d.size - d.live; garbage > compactMinGarbage && garbage > d.live {
+		if err := d.compact(); err != nil {
+			d.err = fmt.Errorf("compaction of %s failed: %w", d.path, err)
+			fmt.Printf("[STORE] %v\n", d.err)
+			return d.err
+		}
+	}
+	return nil
+}
+
+// writeRecord writes payload as a record at off.
+func writeRecord(f *os.File, off int64, payload []byte) error {
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record, uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:], crc32.Checksum(payload, crcTable))
+	_, err := f.WriteAt(append(record, payload...), off)
+	return err
+}
+
+// compact rewrites the live entries to a new file and swaps it in. Once
+// the new file has replaced the log, an error leaves d without a usable
+// file or index.
+func (d *Disk) compact() error {
+	tmpPath := d.path + ".compact"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	keys := make([]string, 0, len(d.index))
+	for k := range d.index {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var off int64
+	var ops []op
+	size := 0
+	flush := func() error {
+		payload := encodeOps(ops)
+		if err := writeRecord(tmp, off, payload); err != nil {
+			return err
+		}
+		off += recordHeaderSize + int64(len(payload))
+		ops, size = ops[:0], 0
+		return nil
+	}
+	for _, k := range keys {
+		v, err := d.read(d.index[k])
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		ops = append(ops, op{opPut, []byte(k), v})
+		if size += len(k) + len(v); size >= maxRecordSize {
+			if err := flush(); err != nil {
+				tmp.Close()
+				return err
+			}
+		}
+	}
+	if len(ops) > 0 {
+		if err := flush(); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, d.path); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(d.path)); err != nil {
+		return err
+	}
+
+	d.f.Close()
+	f, err := os.OpenFile(d.path, os.O_RDWR, 0644)
+	if err != nil {
+		d.f = nil
+		return err
+	}
+	d.f, d.index, d.size, d.live = f, make(map[string]location), 0, 0
+	return d.load()
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+func (d *Disk) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.f == nil {
+		return nil
+	}
+	err := d.f.Close()
+	d.f = nil
+	return err
+}
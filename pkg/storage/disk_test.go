@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"zar-blockchain/pkg/blockchain"
+)
+
+func openTestDisk(t *testing.T, path string) *Disk {
+	t.Helper()
+	d, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func put(t *testing.T, d *Disk, kv ...string) {
+	t.Helper()
+	b := d.NewBatch()
+	for i := 0; i < len(kv); i += 2 {
+		b.Put([]byte(kv[i]), []byte(kv[i+1]))
+	}
+	if err := b.Write(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkValues checks that d holds exactly want.
+func checkValues(t *testing.T, d *Disk, want map[string]string) {
+	t.Helper()
+	got := make(map[string]string)
+	if err := d.Iterate(nil, func(k, v []byte) error {
+		got[string(k)] = string(v)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Errorf("store holds %d keys, want %d", len(got), len(want))
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestDiskReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	d := openTestDisk(t, path)
+	put(t, d, "a", "1", "b", "2", "c", "3")
+	put(t, d, "a", "4")
+	b := d.NewBatch()
+	b.Delete([]byte("b"))
+	if err := b.Write(); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	d = openTestDisk(t, path)
+	checkValues(t, d, map[string]string{"a": "4", "c": "3"})
+	if _, err := d.Get([]byte("b")); !errors.Is(err, blockchain.ErrNotFound) {
+		t.Errorf("Get(b) = %v, want ErrNotFound", err)
+	}
+}
+
+// TestDiskTornWrite checks that a record cut short by a crash is dropped
+// on Open and the log carries on after the last complete one.
+func TestDiskTornWrite(t *testing.T) {
+	tests := []struct {
+		name string
+		tail func(record []byte) []byte
+	}{
+		{"header", func(r []byte) []byte { return r[:recordHeaderSize-3] }},
+		{"payload", func(r []byte) []byte { return r[:len(r)-2] }},
+		{"checksum", func(r []byte) []byte { r[len(r)-1] ^= 1; return r }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db")
+			d := openTestDisk(t, path)
+			put(t, d, "a", "1")
+			good := fileSize(t, path)
+			put(t, d, "b", "2")
+			d.Close()
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data = append(data[:good], tt.tail(data[good:])...)
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			d = openTestDisk(t, path)
+			checkValues(t, d, map[string]string{"a": "1"})
+			if size := fileSize(t, path); size != good {
+				t.Errorf("log is %d bytes after recovery, want %d", size, good)
+			}
+			put(t, d, "c", "3")
+			d.Close()
+			d = openTestDisk(t, path)
+			checkValues(t, d, map[string]string{"a": "1", "c": "3"})
+		})
+	}
+}
+
+func TestDiskCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	d := openTestDisk(t, path)
+	put(t, d, "a", "1")
+	put(t, d, "b", "2")
+	d.Close()
+
+	// Damage the first record, which a crash can't have left incomplete
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[recordHeaderSize+1] ^= 1
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := Open(path); !errors.Is(err, ErrCorrupt) {
+		if err == nil {
+			d.Close()
+		}
+		t.Fatalf("Open = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestDiskCompact(t *testing.T) {
+	defer func(n int64) { compactMinGarbage = n }(compactMinGarbage)
+	compactMinGarbage = 4 << 10
+
+	path := filepath.Join(t.TempDir(), "db")
+	d := openTestDisk(t, path)
+	want := make(map[string]string)
+	var peak int64
+	for i := range 1000 {
+		k, v := fmt.Sprintf("key%d", i%10), fmt.Sprintf("value%d", i)
+		put(t, d, k, v)
+		want[k] = v
+		peak = max(peak, fileSize(t, path))
+	}
+	if size := fileSize(t, path); size >= peak || peak > 2*compactMinGarbage {
+		t.Errorf("log is %d bytes, peaked at %d: not compacted", size, peak)
+	}
+	checkValues(t, d, want)
+	if _, err := os.Stat(path + ".compact"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("compaction left its output behind: %v", err)
+	}
+	d.Close()
+
+	d = openTestDisk(t, path)
+	checkValues(t, d, want)
+}
+
+// TestDiskCompactFailure checks that a failed compaction fails the
+// commit that triggered it and every call after, rather than being
+// retried on every commit.
+func TestDiskCompactFailure(t *testing.T) {
+	defer func(n int64) { compactMinGarbage = n }(compactMinGarbage)
+	compactMinGarbage = 1 << 10
+
+	path := filepath.Join(t.TempDir(), "db")
+	d := openTestDisk(t, path)
+	// A directory where the compacted log goes makes creating it fail
+	if err := os.MkdirAll(filepath.Join(path+".compact", "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	for i := 0; err == nil && i < 1000; i++ {
+		b := d.NewBatch()
+		b.Put([]byte("key"), []byte(fmt.Sprintf("value%d", i)))
+		err = b.Write()
+	}
+	if err == nil {
+		t.Fatal("compaction never ran")
+	}
+	size := fileSize(t, path)
+
+	b := d.NewBatch()
+	b.Put([]byte("next"), []byte("1"))
+	if err2 := b.Write(); !errors.Is(err2, err) {
+		t.Errorf("commit after failed compaction = %v, want %v", err2, err)
+	}
+	if fileSize(t, path) != size {
+		t.Error("commit after failed compaction wrote to the log")
+	}
+	if _, err2 := d.Get([]byte("key")); !errors.Is(err2, err) {
+		t.Errorf("Get after failed compaction = %v, want %v", err2, err)
+	}
+}
+
+// TestDiskCorruptLength checks that a damaged length in the middle of the
+// log, which makes its record run past the end like a torn write does,
+// is reported rather than cutting off the records after it.
+func TestDiskCorruptLength(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	d := openTestDisk(t, path)
+	put(t, d, "a", "1")
+	mid := fileSize(t, path)
+	put(t, d, "b", "2")
+	put(t, d, "c", "3")
+	d.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[mid] = 0x7f
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := Open(path); !errors.Is(err, ErrCorrupt) {
+		if err == nil {
+			d.Close()
+		}
+		t.Fatalf("Open = %v, want ErrCorrupt", err)
+	}
+	if size := fileSize(t, path); size != int64(len(data)) {
+		t.Errorf("log is %d bytes after Open, want %d untouched", size, len(data))
+	}
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"zar-blockchain/pkg/blockchain"
+)
+
+// Memory is a Database that keeps everything in memory, for tests and
+// throwaway chains.
+type Memory struct {
+	mu   sync.RWMutex
+	data map[string][]byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{data: make(map[string][]byte)}
+}
+
+func (m *Memory) Get(key []byte) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	v, ok := m.data[string(key)]
+	if !ok {
+		return nil, blockchain.ErrNotFound
+	}
+	return append([]byte(nil), v...), nil
+}
+
+func (m *Memory) Has(key []byte) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.data[string(key)]
+	return ok, nil
+}
+
+func (m *Memory) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := make([]string, 0)
+	for k := range m.data {
+		if strings.HasPrefix(k, string(prefix)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := fn([]byte(k), m.data[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) NewBatch() blockchain.Batch {
+	return &batch{commit: m.commit}
+}
+
+func (m *Memory) commit(ops []op) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, o := range ops {
+		if o.kind == opPut {
+			m.data[string(o.key)] = o.value
+		} else {
+			delete(m.data, string(o.key))
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
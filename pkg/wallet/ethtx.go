@@ -0,0 +1,189 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Ethereum transaction envelope types accepted from MetaMask.
+const (
+	LegacyTxType     = 0x00
+	AccessListTxType = 0x01 // EIP-2930
+	DynamicFeeTxType = 0x02 // EIP-1559
+)
+
+var ErrWrongChainID = errors.New("transaction signed for a different chain")
+
+// EthTx is a decoded signed Ethereum transaction together with the hash
+// its sender actually signed.
+type EthTx struct {
+	Type        byte
+	ChainID     *big.Int // nil for unprotected pre-EIP-155 legacy txs
+	Nonce       uint64
+	To          string
+	Value       *big.Int
+	Fee         *big.Int // Tip paid to the block producer: gas limit * effective gas price
+	Hash        string   // keccak256 of the raw envelope, as reported to wallets
+	SigningHash []byte
+	V, R, S     *big.Int
+}
+
+type legacyTx struct {
+	Nonce    uint64
+	GasPrice *big.Int
+	Gas      uint64
+	To       []byte
+	Value    *big.Int
+	Data     []byte
+	V, R, S  *big.Int
+}
+
+type accessListTx struct {
+	ChainID    *big.Int
+	Nonce      uint64
+	GasPrice   *big.Int
+	Gas        uint64
+	To         []byte
+	Value      *big.Int
+	Data       []byte
+	AccessList rlp.RawValue
+	V, R, S    *big.Int
+}
+
+type dynamicFeeTx struct {
+	ChainID    *big.Int
+	Nonce      uint64
+	GasTipCap  *big.Int
+	GasFeeCap  *big.Int
+	Gas        uint64
+	To         []byte
+	Value      *big.Int
+	Data       []byte
+	AccessList rlp.RawValue
+	V, R, S    *big.Int
+}
+
+// DecodeEthTx decodes a raw signed legacy, EIP-2930 or EIP-1559
+// transaction and reconstructs its signing hash.
+func DecodeEthTx(raw []byte) (*EthTx, error) {
+	if len(raw) == 0 {
+		return nil, errors.New("empty transaction")
+	}
+
+	tx := &EthTx{Hash: crypto.Keccak256Hash(raw).Hex()}
+	var to []byte
+	var err error
+
+	switch {
+	case raw[0] >= 0xc0:
+		// Legacy: rlp([nonce, gasPrice, gas, to, value, data, v, r, s])
+		var t legacyTx
+		if err = rlp.DecodeBytes(raw, &t); err != nil {
+			return nil, fmt.Errorf("legacy tx: %v", err)
+		}
+		tx.Type, tx.Nonce, to, tx.Value = LegacyTxType, t.Nonce, t.To, t.Value
+		tx.Fee = gasFee(t.Gas, t.GasPrice)
+		tx.V, tx.R, tx.S = t.V, t.R, t.S
+
+		if t.V.BitLen() <= 8 && (t.V.Uint64() == 27 || t.V.Uint64() == 28) {
+			// Unprotected: the signature commits to no chain at all
+			tx.SigningHash, err = rlpHash(nil, []interface{}{t.Nonce, t.GasPrice, t.Gas, t.To, t.Value, t.Data})
+		} else {
+			// EIP-155: v = chainId*2 + 35 + yParity
+			tx.ChainID = new(big.Int).Sub(t.V, big.NewInt(35))
+			tx.ChainID.Rsh(tx.ChainID, 1)
+			tx.SigningHash, err = rlpHash(nil, []interface{}{t.Nonce, t.GasPrice, t.Gas, t.To, t.Value, t.Data, tx.ChainID, uint(0), uint(0)})
+		}
+
+	case raw[0] == AccessListTxType:
+		var t accessListTx
+		if err = rlp.DecodeBytes(raw[1:], &t); err != nil {
+			return nil, fmt.Errorf("EIP-2930 tx: %v", err)
+		}
+		tx.Type, tx.ChainID, tx.Nonce, to, tx.Value = AccessListTxType, t.ChainID, t.Nonce, t.To, t.Value
+		tx.Fee = gasFee(t.Gas, t.GasPrice)
+		tx.V, tx.R, tx.S = t.V, t.R, t.S
+		tx.SigningHash, err = rlpHash([]byte{AccessListTxType}, []interface{}{
+			t.ChainID, t.Nonce, t.GasPrice, t.Gas, t.To, t.Value, t.Data, t.AccessList,
+		})
+
+	case raw[0] == DynamicFeeTxType:
+		var t dynamicFeeTx
+		if err = rlp.DecodeBytes(raw[1:], &t); err != nil {
+			return nil, fmt.Errorf("EIP-1559 tx: %v", err)
+		}
+		tx.Type, tx.ChainID, tx.Nonce, to, tx.Value = DynamicFeeTxType, t.ChainID, t.Nonce, t.To, t.Value
+		// There is no base fee, so the effective price is the tip capped by the fee cap
+		tip := t.GasTipCap
+		if tip.Cmp(t.GasFeeCap) > 0 {
+			tip = t.GasFeeCap
+		}
+		tx.Fee = gasFee(t.Gas, tip)
+		tx.V, tx.R, tx.S = t.V, t.R, t.S
+		tx.SigningHash, err = rlpHash([]byte{DynamicFeeTxType}, []interface{}{
+			t.ChainID, t.Nonce, t.GasTipCap, t.GasFeeCap, t.Gas, t.To, t.Value, t.Data, t.AccessList,
+		})
+
+	default:
+		return nil, fmt.Errorf("unsupported transaction type 0x%02x", raw[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(to) != common.AddressLength {
+		return nil, errors.New("contract creation is not supported")
+	}
+	tx.To = common.BytesToAddress(to).Hex()
+	return tx, nil
+}
+
+// Sender recovers the signing address after checking that the transaction
+// was signed for chainID.
+func (tx *EthTx) Sender(chainID int64) (string, error) {
+	if tx.ChainID == nil || tx.ChainID.Cmp(big.NewInt(chainID)) != 0 {
+		return "", fmt.Errorf("%w: got %v, want %d", ErrWrongChainID, tx.ChainID, chainID)
+	}
+
+	var recID *big.Int
+	if tx.Type == LegacyTxType {
+		// Strip the EIP-155 offset back to a 0/1 recovery id
+		recID = new(big.Int).Sub(tx.V, new(big.Int).Add(new(big.Int).Lsh(tx.ChainID, 1), big.NewInt(35)))
+	} else {
+		recID = tx.V
+	}
+	if !recID.IsUint64() || recID.Uint64() > 1 {
+		return "", errors.New("invalid signature recovery id")
+	}
+	if !crypto.ValidateSignatureValues(byte(recID.Uint64()), tx.R, tx.S, true) {
+		return "", errors.New("invalid signature values")
+	}
+
+	sig := make([]byte, crypto.SignatureLength)
+	tx.R.FillBytes(sig[:32])
+	tx.S.FillBytes(sig[32:64])
+	sig[64] = byte(recID.Uint64())
+
+	pub, err := crypto.SigToPub(tx.SigningHash, sig)
+	if err != nil {
+		return "", err
+	}
+	return crypto.PubkeyToAddress(*pub).Hex(), nil
+}
+
+func gasFee(gas uint64, price *big.Int) *big.Int {
+	return new(big.Int).Mul(new(big.Int).SetUint64(gas), price)
+}
+
+func rlpHash(prefix []byte, fields []interface{}) ([]byte, error) {
+	enc, err := rlp.EncodeToBytes(fields)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Keccak256(prefix, enc), nil
+}
@@ -0,0 +1,139 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// A verifiable random function over secp256k1, in the style of ECVRF
+// (RFC 9381) with try-and-increment hashing to the curve. For a given key
+// and input there is exactly one output, so the key holder can't grind
+// it, and anyone with the proof can check it came from that key.
+
+// VRFProofSize is the length of a proof: the compressed public key, the
+// compressed gamma point, the challenge and the response.
+const VRFProofSize = 33 + 33 + 32 + 32
+
+var ErrInvalidVRFProof = errors.New("invalid VRF proof")
+
+// VRFProve returns the wallet's 32-byte VRF output for alpha and the
+// proof of it.
+func (w *Wallet) VRFProve(alpha []byte) (output, proof []byte, err error) {
+	curve := crypto.S256()
+	n := curve.Params().N
+	pub := crypto.CompressPubkey(&w.PrivateKey.PublicKey)
+
+	hx, hy, err := vrfHashToCurve(pub, alpha)
+	if err != nil {
+		return nil, nil, err
+	}
+	x := w.PrivateKey.D
+	gx, gy := curve.ScalarMult(hx, hy, x.Bytes())
+
+	// Deterministic nonce, so the same input never leaks the key
+	k := new(big.Int).SetBytes(vrfHash(0x04, crypto.FromECDSA(w.PrivateKey), compress(hx, hy)))
+	k.Mod(k, n)
+	if k.Sign() == 0 {
+		return nil, nil, errors.New("VRF nonce is zero")
+	}
+	ux, uy := curve.ScalarBaseMult(k.Bytes())
+	vx, vy := curve.ScalarMult(hx, hy, k.Bytes())
+
+	c := vrfChallenge(hx, hy, gx, gy, ux, uy, vx, vy)
+	s := new(big.Int).Mul(c, x)
+	s.Add(s, k).Mod(s, n)
+
+	gamma := compress(gx, gy)
+	proof = make([]byte, 0, VRFProofSize)
+	proof = append(proof, pub...)
+	proof = append(proof, gamma...)
+	proof = append(proof, c.FillBytes(make([]byte, 32))...)
+	proof = append(proof, s.FillBytes(make([]byte, 32))...)
+	return vrfHash(0x03, gamma), proof, nil
+}
+
+// VRFVerify checks that proof is a VRF proof for alpha by the key owning
+// address and returns the 32-byte output.
+func VRFVerify(address string, alpha, proof []byte) ([]byte, error) {
+	if len(proof) != VRFProofSize {
+		return nil, ErrInvalidVRFProof
+	}
+	curve := crypto.S256()
+	n := curve.Params().N
+
+	pub, err := crypto.DecompressPubkey(proof[:33])
+	if err != nil {
+		return nil, ErrInvalidVRFProof
+	}
+	if !SameAddress(crypto.PubkeyToAddress(*pub).Hex(), address) {
+		return nil, ErrInvalidVRFProof
+	}
+	gamma, err := crypto.DecompressPubkey(proof[33:66])
+	if err != nil {
+		return nil, ErrInvalidVRFProof
+	}
+	c := new(big.Int).SetBytes(proof[66:98])
+	s := new(big.Int).SetBytes(proof[98:])
+	if c.Sign() == 0 || c.Cmp(n) >= 0 || s.Cmp(n) >= 0 {
+		return nil, ErrInvalidVRFProof
+	}
+
+	hx, hy, err := vrfHashToCurve(proof[:33], alpha)
+	if err != nil {
+		return nil, err
+	}
+	negC := new(big.Int).Sub(n, c).Bytes()
+
+	// U = s*G - c*Y and V = s*H - c*Gamma
+	sgx, sgy := curve.ScalarBaseMult(s.Bytes())
+	cyx, cyy := curve.ScalarMult(pub.X, pub.Y, negC)
+	ux, uy := curve.Add(sgx, sgy, cyx, cyy)
+	shx, shy := curve.ScalarMult(hx, hy, s.Bytes())
+	cgx, cgy := curve.ScalarMult(gamma.X, gamma.Y, negC)
+	vx, vy := curve.Add(shx, shy, cgx, cgy)
+
+	if vrfChallenge(hx, hy, gamma.X, gamma.Y, ux, uy, vx, vy).Cmp(c) != 0 {
+		return nil, ErrInvalidVRFProof
+	}
+	return vrfHash(0x03, proof[33:66]), nil
+}
+
+// vrfHashToCurve maps pub and alpha to a curve point by hashing with an
+// increasing counter until the digest is the x coordinate of a point.
+func vrfHashToCurve(pub, alpha []byte) (*big.Int, *big.Int, error) {
+	for ctr := 0; ctr < 256; ctr++ {
+		x := vrfHash(0x01, pub, alpha, []byte{byte(ctr)})
+		if p, err := crypto.DecompressPubkey(append([]byte{0x02}, x...)); err == nil {
+			return p.X, p.Y, nil
+		}
+	}
+	return nil, nil, errors.New("VRF input does not map to the curve")
+}
+
+func vrfChallenge(points ...*big.Int) *big.Int {
+	var buf bytes.Buffer
+	for i := 0; i < len(points); i += 2 {
+		buf.Write(compress(points[i], points[i+1]))
+	}
+	c := new(big.Int).SetBytes(vrfHash(0x02, buf.Bytes()))
+	return c.Mod(c, crypto.S256().Params().N)
+}
+
+// vrfHash is sha256 over a domain separation byte and parts.
+func vrfHash(domain byte, parts ...[]byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{domain})
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+func compress(x, y *big.Int) []byte {
+	return crypto.CompressPubkey(&ecdsa.PublicKey{Curve: crypto.S256(), X: x, Y: y})
+}
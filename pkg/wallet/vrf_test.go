@@ -0,0 +1,139 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func newTestWallet(t *testing.T) *Wallet {
+	t.Helper()
+	w, err := NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestVRFRoundTrip(t *testing.T) {
+	w := newTestWallet(t)
+	seen := make(map[string]bool)
+	for _, alpha := range [][]byte{nil, []byte("a"), []byte("b"), bytes.Repeat([]byte{0xff}, 100)} {
+		out, proof, err := w.VRFProve(alpha)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out) != 32 || len(proof) != VRFProofSize {
+			t.Fatalf("output of %d bytes and proof of %d bytes", len(out), len(proof))
+		}
+		got, err := VRFVerify(w.Address, alpha, proof)
+		if err != nil {
+			t.Fatalf("VRFVerify(%q) = %v", alpha, err)
+		}
+		if !bytes.Equal(got, out) {
+			t.Errorf("VRFVerify(%q) output %x, VRFProve gave %x", alpha, got, out)
+		}
+		if seen[string(out)] {
+			t.Errorf("output %x repeated for %q", out, alpha)
+		}
+		seen[string(out)] = true
+
+		// The output is unique, so proving again can't give another one
+		again, proof2, err := w.VRFProve(alpha)
+		if err != nil || !bytes.Equal(again, out) || !bytes.Equal(proof2, proof) {
+			t.Errorf("VRFProve(%q) is not deterministic", alpha)
+		}
+	}
+}
+
+// TestVRFKnownAnswer pins the output of a fixed key, as every node has to
+// compute the same leader from it.
+func TestVRFKnownAnswer(t *testing.T) {
+	key, err := crypto.HexToECDSA("c85ef7d79691fe79573b1a7064c19c1a9819ebdbd1faaab1a8ec92344438aaf4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := FromPrivateKey(key)
+	out, proof, err := w.VRFProve([]byte("zar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const (
+		wantOut   = "e661545546e070217729575e6e5ea97e2b316771d7aa634fa172bb9e7e3316ac"
+		wantProof = "030947751e3022ecf3016be03ec77ab0ce3c2662b4843898cb068d74f698ccc8ad02fdf65b718581969abcbe0cf2551b13aa016ab62bb83d712a26da737cf00bb1c31b5837dea4c27f6cc1870a3477502d6d876e569a2b8bb0533734a58d68450fc957c3105442f180112655df2caaadfea5c8da86d3d1c6f0d6a48aa032bf25c670"
+	)
+	if got := hex.EncodeToString(out); got != wantOut {
+		t.Errorf("output %s, want %s", got, wantOut)
+	}
+	if got := hex.EncodeToString(proof); got != wantProof {
+		t.Errorf("proof %s, want %s", got, wantProof)
+	}
+}
+
+func TestVRFRejectsTampering(t *testing.T) {
+	w := newTestWallet(t)
+	alpha := []byte("block 42")
+	_, proof, err := w.VRFProve(alpha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A gamma that is a valid point, just not the one for alpha
+	_, other, err := w.VRFProve([]byte("block 43"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := crypto.S256().Params().N
+
+	tamper := func(f func(p []byte)) []byte {
+		p := bytes.Clone(proof)
+		f(p)
+		return p
+	}
+	tests := []struct {
+		name  string
+		alpha []byte
+		proof []byte
+	}{
+		{"alpha", []byte("block 43"), proof},
+		{"gamma", alpha, tamper(func(p []byte) { copy(p[33:66], other[33:66]) })},
+		{"gamma bit", alpha, tamper(func(p []byte) { p[65] ^= 1 })},
+		{"c", alpha, tamper(func(p []byte) { p[97] ^= 1 })},
+		{"c zero", alpha, tamper(func(p []byte) { clear(p[66:98]) })},
+		{"c out of range", alpha, tamper(func(p []byte) { n.FillBytes(p[66:98]) })},
+		{"s", alpha, tamper(func(p []byte) { p[129] ^= 1 })},
+		{"s out of range", alpha, tamper(func(p []byte) { new(big.Int).Add(n, big.NewInt(1)).FillBytes(p[98:]) })},
+		{"short", alpha, proof[:VRFProofSize-1]},
+		{"long", alpha, append(bytes.Clone(proof), 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if out, err := VRFVerify(w.Address, tt.alpha, tt.proof); !errors.Is(err, ErrInvalidVRFProof) {
+				t.Errorf("VRFVerify = %x, %v, want ErrInvalidVRFProof", out, err)
+			}
+		})
+	}
+}
+
+func TestVRFWrongAddress(t *testing.T) {
+	w, other := newTestWallet(t), newTestWallet(t)
+	alpha := []byte("block 42")
+	_, proof, err := w.VRFProve(alpha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VRFVerify(other.Address, alpha, proof); !errors.Is(err, ErrInvalidVRFProof) {
+		t.Errorf("proof by %s verified for %s: %v", w.Address, other.Address, err)
+	}
+
+	// Claiming another key's proof by swapping in one's own public key
+	// breaks the challenge
+	swapped := bytes.Clone(proof)
+	copy(swapped, crypto.CompressPubkey(&other.PrivateKey.PublicKey))
+	if _, err := VRFVerify(other.Address, alpha, swapped); !errors.Is(err, ErrInvalidVRFProof) {
+		t.Errorf("proof by %s verified with the key of %s: %v", w.Address, other.Address, err)
+	}
+}
@@ -3,10 +3,13 @@ package wallet
 import (
 	"crypto/ecdsa"
 	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
-
 type Wallet struct {
 	PrivateKey *ecdsa.PrivateKey
 	PublicKey  []byte
@@ -18,15 +21,36 @@ func NewWallet() (*Wallet, error) {
 	if err != nil {
 		return nil, err
 	}
+	return FromPrivateKey(privateKey), nil
+}
 
-	publicKey := crypto.FromECDSAPub(&privateKey.PublicKey)
-	address := crypto.PubkeyToAddress(privateKey.PublicKey).Hex()
-
+// FromPrivateKey returns the wallet of an existing key.
+func FromPrivateKey(privateKey *ecdsa.PrivateKey) *Wallet {
 	return &Wallet{
 		PrivateKey: privateKey,
-		PublicKey:  publicKey,
-		Address:    address,
-	}, nil
+		PublicKey:  crypto.FromECDSAPub(&privateKey.PublicKey),
+		Address:    crypto.PubkeyToAddress(privateKey.PublicKey).Hex(),
+	}
+}
+
+// LoadOrCreate reads the hex-encoded private key in path, generating a
+// new key and saving it there if the file doesn't exist yet.
+func LoadOrCreate(path string) (*Wallet, error) {
+	privateKey, err := crypto.LoadECDSA(path)
+	if err == nil {
+		return FromPrivateKey(privateKey), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	w, err := NewWallet()
+	if err != nil {
+		return nil, err
+	}
+	if err := crypto.SaveECDSA(path, w.PrivateKey); err != nil {
+		return nil, err
+	}
+	return w, nil
 }
 
 func (w *Wallet) Sign(data []byte) (string, error) {
@@ -39,8 +63,10 @@ func (w *Wallet) Sign(data []byte) (string, error) {
 	return hex.EncodeToString(signature), nil
 }
 
+// VerifySignature reports whether sigHex is a signature of data by the key
+// owning address. Addresses are compared case-insensitively.
 func VerifySignature(address string, data []byte, sigHex string) bool {
-	sig, err := hex.DecodeString(sigHex)
+	sig, err := hex.DecodeString(strings.TrimPrefix(sigHex, "0x"))
 	if err != nil {
 		return false
 	}
@@ -52,5 +78,10 @@ func VerifySignature(address string, data []byte, sigHex string) bool {
 	}
 
 	recoveredAddr := crypto.PubkeyToAddress(*pubKey).Hex()
-	return recoveredAddr == address
+	return SameAddress(recoveredAddr, address)
+}
+
+// SameAddress compares two hex addresses ignoring checksum casing.
+func SameAddress(a, b string) bool {
+	return strings.EqualFold(a, b)
 }